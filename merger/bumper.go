@@ -0,0 +1,66 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+)
+
+// bumperBefore reports whether the bumper plays before videos[index],
+// which is the case after every Bumper.EveryN clips but never before the
+// first one.
+func (p mergePlan) bumperBefore(index int) bool {
+	return p.bumper != "" && index > 0 && index%p.bumperEvery == 0
+}
+
+// bumpers returns how many times the bumper plays.
+func (p mergePlan) bumpers() int {
+	n := 0
+	for i := range p.videos {
+		if p.bumperBefore(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// encodeBumper re-encodes config.Bumper.Path to output in the format the
+// cards are encoded in: fitted to the frame, at the output rate, and with
+// its audio resampled to audio. A bumper without audio gets a silent
+// track so it still joins with stream copy.
+func encodeBumper(config Config, output string, audio AudioConfig) error {
+	probed, err := probeAudio(config, config.Bumper.Path)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"-y"}, config.hwaccelArgs()...)
+	args = append(args, "-i", config.Bumper.Path)
+	if probed.SampleRate == 0 {
+		silence := fmt.Sprintf("anullsrc=r=%d:cl=%s", audio.SampleRate, audio.ChannelLayout)
+		args = append(args, "-f", "lavfi", "-i", silence, "-map", "0:v:0", "-map", "1:a", "-shortest")
+	} else {
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0",
+			"-af", fmt.Sprintf("aresample=%d,aformat=channel_layouts=%s", audio.SampleRate, audio.ChannelLayout))
+	}
+	args = append(args, "-vf", fmt.Sprintf("%s,fps=%d", fitFilter(config), config.outputRate()))
+	args = append(args, config.intermediateEncoderArgs()...)
+	args = append(args, config.FFmpegArgs...)
+	cmd := config.ffmpegCommand(append(args, output)...)
+	cmd.Stdout = config.logger().FFmpegOutput()
+	cmd.Stderr = config.logger().FFmpegOutput()
+	return config.run(cmd)
+}
+
+func validateBumper(config Config) []error {
+	bumper := config.Bumper
+	if bumper.Path == "" {
+		return nil
+	}
+	var errs []error
+	if _, err := os.Stat(bumper.Path); err != nil {
+		errs = append(errs, fmt.Errorf("bumper.path '%s' is not readable: %w", bumper.Path, err))
+	}
+	if bumper.EveryN <= 0 {
+		errs = append(errs, fmt.Errorf("bumper.everyN must be > 0, got %d", bumper.EveryN))
+	}
+	return errs
+}
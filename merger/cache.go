@@ -0,0 +1,224 @@
+package merger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// cardCache keeps encoded cards between runs, keyed by a hash of everything
+// that affects how they look. It lives beside the run directories in the
+// intermediate dir, so it survives cleanup.
+type cardCache struct {
+	dir string
+	// Entries maps a card hash to its clip's file name within dir.
+	Entries map[string]string `json:"entries"`
+}
+
+const cacheManifest = "manifest.json"
+
+// loadCardCache reads the cache manifest under intermediateDir. A missing
+// or unreadable manifest starts an empty cache.
+func loadCardCache(intermediateDir string) (*cardCache, error) {
+	dir := filepath.Join(intermediateDir, "cache")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %w", err)
+	}
+	cache := &cardCache{dir: dir}
+	if data, err := os.ReadFile(filepath.Join(dir, cacheManifest)); err == nil {
+		json.Unmarshal(data, cache)
+	}
+	if cache.Entries == nil {
+		cache.Entries = map[string]string{}
+	}
+	return cache, nil
+}
+
+// lookup returns the cached clip for hash, if it's still on disk.
+func (c *cardCache) lookup(hash string) (string, bool) {
+	name, ok := c.Entries[hash]
+	if !ok {
+		return "", false
+	}
+	path := filepath.Join(c.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		delete(c.Entries, hash)
+		return "", false
+	}
+	return path, true
+}
+
+// store copies the clip at path into the cache under hash.
+func (c *cardCache) store(hash, path string) error {
+	name := hash + filepath.Ext(path)
+	dest := filepath.Join(c.dir, name)
+	if err := os.Link(path, dest); err != nil {
+		if err := copyFile(path, dest); err != nil {
+			return err
+		}
+	}
+	c.Entries[hash] = name
+	return nil
+}
+
+// save writes the manifest, replacing the old one in a single rename so a
+// concurrent run never reads a partial file.
+func (c *cardCache) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, cacheManifest+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(c.dir, cacheManifest))
+}
+
+// rename is os.Rename, swapped out by tests to act like another
+// filesystem.
+var rename = os.Rename
+
+// moveFile renames src to dest, copying it across instead when they're on
+// different filesystems, as a temp dir on tmpfs is from most outputs.
+func moveFile(src, dest string) error {
+	err := rename(src, dest)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(src, dest); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fileStamp identifies a file's contents by size and modification time, so
+// the cache notices when a font or image is replaced in place.
+func fileStamp(path string) string {
+	if path == "" {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	return fmt.Sprintf("%s|%d|%d", path, info.Size(), info.ModTime().UnixNano())
+}
+
+// fallbackStamps is fileStamp for each fallback font.
+func fallbackStamps(paths []string) []string {
+	stamps := make([]string, len(paths))
+	for i, path := range paths {
+		stamps[i] = fileStamp(path)
+	}
+	return stamps
+}
+
+// cardHash hashes the inputs that determine a card's encoded clip: its text
+// and length, the font, frame, frame format, style, and logo settings, and
+// how it's encoded.
+func cardHash(config Config, c card, silence string) string {
+	config = config.withStyle(c.style)
+	text := config.Text
+	// The caption template and durations are already resolved into the
+	// card's text and frame count.
+	text.Caption = ""
+	text.Duration = 0
+	text.Durations = nil
+	text.NoTransitionBefore = nil
+	// Thread counts change how fast a card encodes, not how it looks.
+	encoding := config
+	encoding.Threads = 0
+	// Where a card falls in the merge only shows in the progress bar, so
+	// adding a clip doesn't change the cards without one.
+	progress := c.progress
+	if !text.ShowProgressBar {
+		progress = 0
+	}
+	key := struct {
+		Text        string
+		Frames      int
+		Progress    float64
+		Fit         bool
+		Font        FontConfig
+		FontFile    string
+		Fallbacks   []string
+		Width       int
+		Height      int
+		Rate        int
+		OutputRate  int
+		Supersample int
+		FrameFormat string
+		Quality     int
+		Style       TextConfig
+		Image       string
+		Logo        LogoConfig
+		LogoFile    string
+		Silence     string
+		Format      string
+		Arguments   []string
+		Preview     string
+	}{
+		Text:        c.text,
+		Frames:      c.frames,
+		Progress:    progress,
+		Fit:         c.fit,
+		Font:        config.Font,
+		FontFile:    fileStamp(config.Font.Path),
+		Fallbacks:   fallbackStamps(config.Font.Fallbacks),
+		Width:       config.Frame.Width,
+		Height:      config.Frame.Height,
+		Rate:        config.Frame.Rate,
+		OutputRate:  config.outputRate(),
+		Supersample: config.Frame.Supersample,
+		FrameFormat: config.FrameFormat,
+		Quality:     config.FrameQuality,
+		Style:       text,
+		Image:       fileStamp(config.Text.BackgroundImage),
+		Logo:        config.Logo,
+		LogoFile:    fileStamp(config.Logo.Path),
+		Silence:     silence,
+		Format:      config.intermediateFormat(),
+		Arguments:   append(encoding.intermediateEncoderArgs(), config.FFmpegArgs...),
+	}
+	if c.preview != "" {
+		key.Preview = fmt.Sprintf("%s@%g fit %s %s dim %g", fileStamp(c.preview), c.previewAt,
+			config.Fit, config.PadColor, config.PreviewBackground.Dim)
+	}
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,283 @@
+package merger
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// card is one generated text clip: a transition between two videos, the
+// optional intro and outro, or a table of contents page.
+type card struct {
+	// name distinguishes the card's frames, e.g. "003" or "intro".
+	// Transition indexes are zero-padded so every name has the same
+	// width and no card's frame pattern overlaps another's.
+	name string
+	// dir holds the card's frames and video.
+	dir    string
+	video  string
+	text   string
+	frames int
+	// progress is the share of the source videos already shown when the
+	// card plays, from 0 before the first to 1 after the last.
+	progress float64
+	// style is the card's entry in config.cardStyles() counting from 1,
+	// or 0 for the configured style.
+	style int
+	// preview is the clip whose frame at previewAt, in seconds, becomes
+	// the card's background once extracted to background.
+	preview    string
+	previewAt  float64
+	background string
+	// fit shrinks the font for a text too long for the frame.
+	fit bool
+	// still marks a card whose frames are all the same, so it's drawn as
+	// a single image that's looped for the card's length.
+	still bool
+	// frameExt is the extension of its frame images, "png" or "jpg".
+	frameExt string
+	// cached marks a card whose video was reused from the card cache, so
+	// its frames aren't generated or encoded.
+	cached bool
+}
+
+func (c card) framePath(frame int) string {
+	return fmt.Sprintf("%s/text_%s_frame_%05d.%s", c.dir, c.name, frame, c.frameExt)
+}
+
+func (c card) framePattern() string {
+	return fmt.Sprintf("%s/text_%s_frame_%%05d.%s", c.dir, c.name, c.frameExt)
+}
+
+// mergePlan lays out the generated cards around the source videos.
+// Transitions are keyed by the index of the video they precede.
+type mergePlan struct {
+	videos      []string
+	intro       *card
+	toc         []card
+	transitions map[int]card
+	outro       *card
+	// bumper is where the re-encoded bumper is written, or empty for
+	// none. It plays before every bumperEvery-th video.
+	bumper      string
+	bumperEvery int
+}
+
+// planMerge lays out the cards for sources, in merge order, placing their
+// files in dir. Each card takes its per-source settings from the entry at
+// its index, so a clip listed twice can be set up differently each time.
+func planMerge(config Config, sources []SourceEntry, dir string) mergePlan {
+	videos := paths(sources)
+	plan := mergePlan{videos: videos, transitions: map[int]card{}}
+	styles := config.pickStyles(len(videos))
+	ext := config.intermediateFormat()
+	frameExt := "png"
+	if config.FrameFormat == FrameFormatJPEG {
+		frameExt = "jpg"
+	}
+
+	if config.Intro.Text != "" {
+		plan.intro = &card{
+			name:     "intro",
+			dir:      dir,
+			frameExt: frameExt,
+			video:    filepath.Join(dir, "text_intro."+ext),
+			text:     config.Intro.Text,
+			frames:   config.Frame.Rate * config.Intro.Duration,
+		}
+	}
+	if config.TOC.Enabled {
+		for p, page := range tocPages(config, videos) {
+			plan.toc = append(plan.toc, card{
+				name:     fmt.Sprintf("toc_%03d", p),
+				dir:      dir,
+				frameExt: frameExt,
+				video:    filepath.Join(dir, fmt.Sprintf("text_toc_%03d.%s", p, ext)),
+				text:     page,
+				frames:   config.Frame.Rate * config.TOC.Duration,
+				fit:      true,
+			})
+		}
+	}
+	for i, video := range videos {
+		// Crossfades replace the transition cards entirely.
+		if i == 0 || config.TransitionType == TransitionXfade {
+			continue
+		}
+		// A zero-length transition means the clips follow each other directly.
+		frames := config.Frame.Rate * transitionDuration(config, i, video)
+		if frames == 0 || !transitionEnabled(config, i, sources[i]) {
+			continue
+		}
+		style := styles[i]
+		if named := config.transitionStyle(i, sources[i]); named > 0 {
+			style = named
+		}
+		var preview string
+		var previewAt float64
+		if config.PreviewBackground.Enabled {
+			preview, previewAt = video, previewStart(sources[i])
+		}
+		plan.transitions[i] = card{
+			name:      fmt.Sprintf("%03d", i),
+			dir:       dir,
+			frameExt:  frameExt,
+			video:     filepath.Join(dir, fmt.Sprintf("text_transition_%03d.%s", i, ext)),
+			text:      transitionText(config, videos, i),
+			frames:    frames,
+			progress:  float64(i) / float64(len(videos)),
+			style:     style,
+			preview:   preview,
+			previewAt: previewAt,
+		}
+	}
+	if config.Bumper.Path != "" {
+		plan.bumper = filepath.Join(dir, "bumper."+ext)
+		plan.bumperEvery = config.Bumper.EveryN
+	}
+	if config.Outro.Text != "" {
+		plan.outro = &card{
+			name:     "outro",
+			dir:      dir,
+			frameExt: frameExt,
+			video:    filepath.Join(dir, "text_outro."+ext),
+			text:     config.Outro.Text,
+			frames:   config.Frame.Rate * config.Outro.Duration,
+			progress: 1,
+		}
+	}
+
+	// Animations and fades change from frame to frame.
+	fades := config.Text.FadeInFrames > 0 || config.Text.FadeOutFrames > 0
+	plan.update(func(c *card) {
+		c.still = !fades && static(config.withStyle(c.style))
+	})
+
+	return plan
+}
+
+// images returns how many frame images the card is drawn as.
+func (c card) images() int {
+	if c.still {
+		return 1
+	}
+	return c.frames
+}
+
+// tocPages lists the caption of each of videos, numbered and on one line,
+// TOC.PerPage to a page.
+func tocPages(config Config, videos []string) []string {
+	var pages []string
+	var page []string
+	for i := range videos {
+		caption := strings.Join(strings.Fields(transitionText(config, videos, i)), " ")
+		page = append(page, fmt.Sprintf("%d. %s", i+1, caption))
+		if len(page) == config.TOC.PerPage || i == len(videos)-1 {
+			pages = append(pages, strings.Join(page, "\n"))
+			page = nil
+		}
+	}
+	return pages
+}
+
+// transitionDuration returns the length in seconds of the transition
+// before videos[index], checking overrides by path, base name, and index.
+func transitionDuration(config Config, index int, video string) int {
+	for _, key := range []string{video, filepath.Base(sourcePath(video)), strconv.Itoa(index)} {
+		if d, ok := config.Text.Durations[key]; ok {
+			return d
+		}
+	}
+	return config.Text.Duration
+}
+
+// transitionEnabled reports whether a card goes before source, the entry
+// at index in merge order. "transition": false on the entry or a
+// noTransitionBefore match by path, base name, or index turns it off.
+func transitionEnabled(config Config, index int, source SourceEntry) bool {
+	if source.Transition != nil && !*source.Transition {
+		return false
+	}
+	video := source.Path
+	keys := []string{video, filepath.Base(sourcePath(video)), strconv.Itoa(index)}
+	for _, key := range config.Text.NoTransitionBefore {
+		if slices.Contains(keys, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// cards returns every card in concat order.
+func (p mergePlan) cards() []card {
+	var cards []card
+	if p.intro != nil {
+		cards = append(cards, *p.intro)
+	}
+	cards = append(cards, p.toc...)
+	for i := range p.videos {
+		if t, ok := p.transitions[i]; ok {
+			cards = append(cards, t)
+		}
+	}
+	if p.outro != nil {
+		cards = append(cards, *p.outro)
+	}
+	return cards
+}
+
+// update calls fn on every card in the plan, keeping its changes.
+func (p *mergePlan) update(fn func(c *card)) {
+	if p.intro != nil {
+		fn(p.intro)
+	}
+	for i := range p.toc {
+		fn(&p.toc[i])
+	}
+	for i, t := range p.transitions {
+		fn(&t)
+		p.transitions[i] = t
+	}
+	if p.outro != nil {
+		fn(p.outro)
+	}
+}
+
+// pending returns the cards that still need to be generated.
+func (p mergePlan) pending() []card {
+	var pending []card
+	for _, c := range p.cards() {
+		if !c.cached {
+			pending = append(pending, c)
+		}
+	}
+	return pending
+}
+
+// segments returns the files to merge in order: the cards and bumpers
+// interleaved with inputs, which are the (possibly normalized) source
+// videos.
+func (p mergePlan) segments(inputs []string) []string {
+	var segments []string
+	if p.intro != nil {
+		segments = append(segments, p.intro.video)
+	}
+	for _, c := range p.toc {
+		segments = append(segments, c.video)
+	}
+	for i, input := range inputs {
+		if p.bumperBefore(i) {
+			segments = append(segments, p.bumper)
+		}
+		if t, ok := p.transitions[i]; ok {
+			segments = append(segments, t.video)
+		}
+		segments = append(segments, input)
+	}
+	if p.outro != nil {
+		segments = append(segments, p.outro.video)
+	}
+	return segments
+}
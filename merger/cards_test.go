@@ -0,0 +1,84 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestFramePatternsDontOverlap checks that each card's ffmpeg input
+// pattern matches only its own frames, so text_001_* never picks up
+// text_010_* frames from the same directory.
+func TestFramePatternsDontOverlap(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Frame.Rate = 1
+	config.Text.Duration = 2
+	config.Text.TextAnimation = AnimationSlideUp
+	config.Intro.Text = "Intro"
+	var videos []string
+	for i := 0; i < 12; i++ {
+		videos = append(videos, fmt.Sprintf("clip%d.mp4", i))
+	}
+	cards := planMerge(config, SourcePaths(videos), dir).cards()
+
+	owner := map[string]string{}
+	for _, c := range cards {
+		for j := 0; j < c.frames; j++ {
+			path := c.framePath(j)
+			if err := os.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			owner[filepath.Base(path)] = c.name
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cards {
+		// image2 expands %05d to exactly five digits.
+		pattern := regexp.QuoteMeta(filepath.Base(c.framePattern()))
+		re := regexp.MustCompile("^" + strings.Replace(pattern, "%05d", `\d{5}`, 1) + "$")
+		matched := 0
+		for _, e := range entries {
+			if !re.MatchString(e.Name()) {
+				continue
+			}
+			matched++
+			if owner[e.Name()] != c.name {
+				t.Errorf("pattern for card %s matches %s, a frame of card %s", c.name, e.Name(), owner[e.Name()])
+			}
+		}
+		if matched != c.frames {
+			t.Errorf("pattern for card %s matches %d frames, want %d", c.name, matched, c.frames)
+		}
+	}
+}
+
+func TestPlanMergeRepeatedSourceSettings(t *testing.T) {
+	config := DefaultConfig()
+	config.Styles = map[string]CardStyle{"section": {}}
+	off := false
+	sources := []SourceEntry{
+		{Path: "a.mp4"},
+		{Path: "b.mp4", Style: "section"},
+		{Path: "a.mp4", Transition: &off},
+		{Path: "b.mp4"},
+	}
+	plan := planMerge(config, sources, t.TempDir())
+
+	if c, ok := plan.transitions[1]; !ok || c.style == 0 {
+		t.Errorf("first b.mp4 card = %+v, want the section style", c)
+	}
+	if _, ok := plan.transitions[2]; ok {
+		t.Error("second a.mp4 has a card, want its transition off")
+	}
+	if c, ok := plan.transitions[3]; !ok || c.style != 0 {
+		t.Errorf("second b.mp4 card = %+v, want the default style, not the first entry's", c)
+	}
+}
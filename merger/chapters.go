@@ -0,0 +1,157 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// chapter is one chapter of the merged video, in seconds.
+type chapter struct {
+	title      string
+	start, end float64
+}
+
+// planChapters returns a chapter per source clip, starting at the card
+// that announces it, or the bumper before that, and titled with its
+// caption, plus the intro and outro.
+func planChapters(config Config, plan mergePlan, inputs []string) ([]chapter, error) {
+	tl := newTimeline(config)
+	var chapters []chapter
+	add := func(title string, segments ...string) error {
+		start := tl.t
+		for _, segment := range segments {
+			if _, err := tl.advance(segment); err != nil {
+				return err
+			}
+		}
+		chapters = append(chapters, chapter{title: title, start: start, end: tl.t})
+		return nil
+	}
+
+	if plan.intro != nil {
+		if err := add(plan.intro.text, plan.intro.video); err != nil {
+			return nil, err
+		}
+	}
+	if len(plan.toc) > 0 {
+		var pages []string
+		for _, c := range plan.toc {
+			pages = append(pages, c.video)
+		}
+		if err := add("Contents", pages...); err != nil {
+			return nil, err
+		}
+	}
+	for i, input := range inputs {
+		var segments []string
+		if plan.bumperBefore(i) {
+			segments = append(segments, plan.bumper)
+		}
+		if c, ok := plan.transitions[i]; ok {
+			segments = append(segments, c.video)
+		}
+		segments = append(segments, input)
+		if err := add(transitionText(config, plan.videos, i), segments...); err != nil {
+			return nil, err
+		}
+	}
+	if plan.outro != nil {
+		if err := add(plan.outro.text, plan.outro.video); err != nil {
+			return nil, err
+		}
+	}
+	// The last segment isn't faded into anything.
+	chapters[len(chapters)-1].end += tl.overlap
+	if config.MaxDuration > 0 {
+		chapters = capChapters(chapters, config.MaxDuration)
+	}
+	return chapters, nil
+}
+
+// timeline tracks where each merged segment starts in the output.
+// Crossfades overlap neighbouring segments, which shifts every later
+// start back.
+type timeline struct {
+	config  Config
+	overlap float64
+	t       float64
+}
+
+func newTimeline(config Config) *timeline {
+	tl := &timeline{config: config}
+	if config.TransitionType == TransitionXfade {
+		tl.overlap = config.Xfade.Duration
+	}
+	return tl
+}
+
+// advance probes segment and returns the time it starts at, moving the
+// timeline past it.
+func (tl *timeline) advance(segment string) (float64, error) {
+	d, err := probeDuration(tl.config, segment)
+	if err != nil {
+		return 0, err
+	}
+	start := tl.t
+	tl.t += d - tl.overlap
+	return start, nil
+}
+
+// escapeMetadata escapes the characters ffmetadata gives meaning to.
+func escapeMetadata(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(s)
+}
+
+// writeChapters writes chapters to path as an ffmetadata file.
+func writeChapters(path string, chapters []chapter) error {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, c := range chapters {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(c.start*1000), int64(c.end*1000), escapeMetadata(c.title))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// addTracks copies input to output with the chapters from the ffmetadata
+// file chapters, the subtitle track from the SRT file subtitles, the
+// global metadata of the video tags, and the configured metadata tags.
+// Any of the files may be empty to leave it out.
+func addTracks(config Config, input, chapters, subtitles, tags, output string) error {
+	args := []string{"-y", "-i", input}
+	maps := []string{"-map", "0"}
+	inputs := 1
+	if chapters != "" {
+		args = append(args, "-i", chapters)
+		maps = append(maps, "-map_chapters", strconv.Itoa(inputs))
+		if tags == "" {
+			maps = append(maps, "-map_metadata", strconv.Itoa(inputs))
+		}
+		inputs++
+	}
+	if subtitles != "" {
+		args = append(args, "-i", subtitles)
+		maps = append(maps, "-map", strconv.Itoa(inputs))
+		inputs++
+	}
+	if tags != "" {
+		args = append(args, "-i", tags)
+		maps = append(maps, "-map_metadata", strconv.Itoa(inputs))
+		if config.outputFormat() == FormatMP4 {
+			// Keep tags mp4 has no standard atom for, such as location.
+			maps = append(maps, "-movflags", "use_metadata_tags")
+		}
+	}
+	args = append(args, maps...)
+	args = append(args, config.Metadata.args()...)
+	args = append(args, "-codec", "copy")
+	if subtitles != "" {
+		args = append(args, "-c:s", config.subtitleCodec())
+	}
+	cmd := config.ffmpegCommand(append(args, output)...)
+	cmd.Stdout = config.logger().FFmpegOutput()
+	cmd.Stderr = config.logger().FFmpegOutput()
+	return config.run(cmd)
+}
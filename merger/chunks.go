@@ -0,0 +1,80 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Merges that pass every segment to one ffmpeg command as its own input,
+// with a filter graph that grows with them, split long segment lists so
+// the command line stays short. Windows caps a whole command line at
+// 32767 characters and Linux caps a single argument, such as the filter
+// graph, at 128 KiB.
+const (
+	// maxFilterInputs caps the inputs of one filter-graph command.
+	maxFilterInputs = 32
+	// maxInputBytes caps the total length of one command's input paths.
+	maxInputBytes = 12 << 10
+)
+
+// chunkSegments splits segments, in order, into runs that each fit in one
+// command.
+func chunkSegments(segments []string) [][]string {
+	var chunks [][]string
+	var chunk []string
+	size := 0
+	for _, segment := range segments {
+		cost := len(segment) + len(" -i ")
+		if len(chunk) > 0 && (len(chunk) == maxFilterInputs || size+cost > maxInputBytes) {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, segment)
+		size += cost
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// mergeChunks merges each chunk with merge into a part in runDir named
+// after name, returning the parts in order. Parts are in the intermediate
+// format, which carries audio even when the output is a GIF.
+func mergeChunks(config Config, runDir, name string, chunks [][]string, merge func(chunk []string, output string) error) ([]string, error) {
+	parts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = filepath.Join(runDir, fmt.Sprintf("%s_part_%03d.%s", name, i, config.intermediateFormat()))
+		config.logger().Infof("Merging part %d/%d (%d segments)", i+1, len(chunks), len(chunk))
+		if err := merge(chunk, parts[i]); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// joinParts joins parts, which were all encoded alike, into output with
+// the concat demuxer. They're stream copied unless the output is a GIF,
+// which they're converted to.
+func joinParts(config Config, listPath string, parts []string, output string) error {
+	list, err := buildFileList(parts)
+	if err != nil {
+		return fmt.Errorf("error building filelist: %w", err)
+	}
+	if err := os.WriteFile(listPath, []byte(list), 0644); err != nil {
+		return fmt.Errorf("error writing filelist: %w", err)
+	}
+	args := append([]string{"-y", "-progress", "pipe:1"}, concatInputArgs(listPath)...)
+	if format := config.outputFormat(); format != config.intermediateFormat() {
+		args = append(args, config.encoderArgs(format)...)
+		args = append(args, config.outputArgs(format)...)
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, output)
+	total := segmentsDuration(config, parts)
+	return config.retry("Concat", func() error {
+		return runWithProgress(config, args, total)
+	})
+}
@@ -0,0 +1,95 @@
+package merger
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Windows' limit on a whole command line, the tighter of the two.
+const maxCommandLine = 32767
+
+func TestChunkSegments500(t *testing.T) {
+	var segments []string
+	for i := 0; i < 500; i++ {
+		segments = append(segments, fmt.Sprintf("/videos/holiday/clip_%03d.mp4", i))
+	}
+	chunks := chunkSegments(segments)
+	if want := (500 + maxFilterInputs - 1) / maxFilterInputs; len(chunks) != want {
+		t.Errorf("got %d chunks, want %d", len(chunks), want)
+	}
+	var joined []string
+	for i, chunk := range chunks {
+		size := 0
+		for _, segment := range chunk {
+			size += len(segment) + len(" -i ")
+		}
+		if len(chunk) > maxFilterInputs || size > maxInputBytes {
+			t.Errorf("chunk %d has %d inputs totalling %d bytes, over the %d input, %d byte bound",
+				i, len(chunk), size, maxFilterInputs, maxInputBytes)
+		}
+		joined = append(joined, chunk...)
+	}
+	if strings.Join(joined, "\n") != strings.Join(segments, "\n") {
+		t.Error("chunks don't hold the segments in order")
+	}
+}
+
+func TestConcatFilter500CommandLength(t *testing.T) {
+	runner := &fakeRunner{}
+	config := testConfig(t, runner, 500)
+	config.ConcatMethod = ConcatMethodFilter
+	if _, err := Merge(config); err != nil {
+		t.Fatal(err)
+	}
+
+	merges := 0
+	for _, call := range runner.ffmpegCalls() {
+		if n := len(strings.Join(append([]string{"ffmpeg"}, call...), " ")); n > maxCommandLine {
+			t.Errorf("command is %d characters, over %d: %.200s...", n, maxCommandLine, strings.Join(call, " "))
+		}
+		if strings.Contains(strings.Join(call, " "), "concat=n=") {
+			merges++
+		}
+	}
+	// With the cards there are 999 segments: 32 filter merges of up to 32
+	// each, which the concat demuxer then joins.
+	if merges != 32 {
+		t.Errorf("got %d filter merges, want 32", merges)
+	}
+}
+
+func TestChunkedGIFOutput(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		method func(*Config)
+	}{
+		{"concat filter", func(c *Config) { c.ConcatMethod = ConcatMethodFilter }},
+		{"crossfade", func(c *Config) { c.TransitionType = TransitionXfade }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{}
+			config := testConfig(t, runner, 40)
+			config.Dest.Output = strings.TrimSuffix(config.Dest.Output, ".mp4") + ".gif"
+			tt.method(&config)
+			if _, err := Merge(config); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := runner.ffmpegCalls()
+			for _, call := range calls[:len(calls)-1] {
+				args := strings.Join(call, " ")
+				if strings.Contains(args, ".gif") {
+					t.Errorf("intermediate step reads or writes a gif: %.300s", args)
+				}
+				if strings.Contains(args, "_part_") && !strings.Contains(args, "-c:a aac") {
+					t.Errorf("part is encoded without audio: %.300s", args)
+				}
+			}
+			last := strings.Join(calls[len(calls)-1], " ")
+			if !strings.HasSuffix(last, ".gif") || !strings.Contains(last, "-c:v gif") {
+				t.Errorf("final join isn't encoded to gif: %.300s", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package merger
+
+import (
+	"os"
+)
+
+// cleanupList tracks intermediate files and directories so they can be
+// removed together on success and on every error path.
+type cleanupList struct {
+	entries []cleanupEntry
+}
+
+type cleanupEntry struct {
+	path string
+	// onlyIfEmpty leaves a directory in place if it still has contents.
+	onlyIfEmpty bool
+}
+
+func (c *cleanupList) add(path string) {
+	c.entries = append(c.entries, cleanupEntry{path: path})
+}
+
+func (c *cleanupList) addIfEmpty(dir string) {
+	c.entries = append(c.entries, cleanupEntry{path: dir, onlyIfEmpty: true})
+}
+
+func (c *cleanupList) run() {
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].onlyIfEmpty {
+			os.Remove(c.entries[i].path)
+		} else {
+			os.RemoveAll(c.entries[i].path)
+		}
+	}
+	c.entries = nil
+}
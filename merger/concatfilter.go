@@ -0,0 +1,62 @@
+package merger
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// concatFilter joins segments with ffmpeg's concat filter instead of the
+// concat demuxer. It always re-encodes, which handles sources with mixed
+// codecs and keeps audio and video in sync where stream copy drifts.
+// Like crossfades, every segment is scaled to the frame config first and
+// must have an audio stream. Long segment lists are joined in parts,
+// which are then joined with the concat demuxer.
+func concatFilter(config Config, runDir string, segments []string, output string) error {
+	return concatFilterInto(config, runDir, segments, output, config.outputFormat())
+}
+
+// concatFilterInto joins segments into output encoded as format.
+func concatFilterInto(config Config, runDir string, segments []string, output, format string) error {
+	if chunks := chunkSegments(segments); len(chunks) > 1 {
+		parts, err := mergeChunks(config, runDir, "concat", chunks, func(chunk []string, part string) error {
+			return concatFilterInto(config, runDir, chunk, part, config.intermediateFormat())
+		})
+		if err != nil {
+			return err
+		}
+		return joinParts(config, filepath.Join(runDir, "parts.txt"), parts, output)
+	}
+
+	audio := resolveAudio(config, segments)
+
+	args := []string{"-y", "-progress", "pipe:1"}
+	for _, segment := range segments {
+		args = append(args, "-i", segment)
+	}
+
+	var graph []string
+	var labels strings.Builder
+	for k := range segments {
+		graph = append(graph,
+			fmt.Sprintf("[%d:v]%s,fps=%d,format=yuv420p[v%d]", k,
+				fitFilter(config), config.outputRate(), k),
+			fmt.Sprintf("[%d:a]aformat=sample_rates=%d:channel_layouts=%s[a%d]", k,
+				audio.SampleRate, audio.ChannelLayout, k))
+		fmt.Fprintf(&labels, "[v%d][a%d]", k, k)
+	}
+	graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=1:a=1[v][a]", labels.String(), len(segments)))
+
+	args = append(args, "-filter_complex", strings.Join(graph, ";"), "-map", "[v]")
+	if config.audioCodec(format) != "" {
+		args = append(args, "-map", "[a]")
+	}
+	args = append(args, config.encoderArgs(format)...)
+	args = append(args, config.outputArgs(format)...)
+	args = append(args, output)
+
+	total := segmentsDuration(config, segments)
+	return config.retry("Concat", func() error {
+		return runWithProgress(config, args, total)
+	})
+}
@@ -0,0 +1,782 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigVersion is the config format this build understands. Configs that
+// omit "version" are assumed to use it.
+const ConfigVersion = 1
+
+// Config describes a merge job: where the sources come from, how the
+// transition cards look, and where the merged video goes.
+type Config struct {
+	Version int         `json:"version"`
+	Dest    Destination `json:"dest"`
+	// Source lists the videos to merge. Entries are paths, or objects with
+	// a path and optional start and end times to merge part of a clip. A
+	// path may be an http:// or https:// URL, which ffmpeg reads directly,
+	// or an s3:// URL, which is downloaded with the AWS CLI for the length
+	// of the merge.
+	Source []SourceEntry `json:"source"`
+	// SourceDir is scanned for videos when Source is empty.
+	SourceDir string     `json:"sourceDir"`
+	Scan      ScanConfig `json:"scan"`
+	// Reverse flips the final merge order, after sorting, so the plan,
+	// cards, and captions all follow the reversed list.
+	Reverse bool `json:"reverse"`
+	// DedupeConsecutive drops a video identical to the one right before it
+	// in merge order, comparing by DedupeBy: "path" (the same file and
+	// trim) or "content" (the same bytes, even under another name).
+	DedupeConsecutive bool   `json:"dedupeConsecutive"`
+	DedupeBy          string `json:"dedupeBy"`
+	// SourceExtensions lists the file extensions picked up when scanning,
+	// matched case-insensitively. The leading dot is optional.
+	SourceExtensions []string          `json:"sourceExtensions"`
+	Font             FontConfig        `json:"font"`
+	Frame            FrameConfig       `json:"frame"`
+	Text             TextConfig        `json:"text"`
+	Titles           map[string]string `json:"titles"`
+	// UseSidecarCaptions reads each clip's caption from a .txt file beside
+	// it with the same base name (beach.mp4 -> beach.txt), falling back to
+	// the caption template when there's none. Titles still take precedence.
+	UseSidecarCaptions bool `json:"useSidecarCaptions"`
+	// PreviewBackground draws each transition card over the first frame of
+	// the clip it introduces.
+	PreviewBackground PreviewConfig `json:"previewBackground"`
+	// RandomStyles gives each transition card one of these styles, picked
+	// at random by its position in the merge order. The same Seed always
+	// picks the same styles. Empty uses the text config for every card.
+	RandomStyles []CardStyle `json:"randomStyles"`
+	Seed         int64       `json:"seed"`
+	// Styles are named card styles, such as one for section breaks. The
+	// card before a video takes the style named by its source entry's
+	// "style", or by TransitionStyles, keyed by the video's path, base
+	// name, or index in the merge order. A named style replaces any random
+	// one; other cards keep the text config.
+	Styles           map[string]CardStyle `json:"styles"`
+	TransitionStyles map[string]string    `json:"transitionStyles"`
+	// Normalize re-encodes every source to the frame config when any
+	// source differs in resolution, frame rate, or codec.
+	Normalize bool `json:"normalize"`
+	// OutputRate is the frame rate of the merged video. Normalized clips,
+	// cards, and crossfades are converted to it with ffmpeg's fps filter
+	// or -r, so footage shot at 24, 30, and 60 fps shares one cadence.
+	// Setting it turns on Normalize. 0 means Frame.Rate.
+	OutputRate int `json:"outputRate"`
+	// FrameFormat is how card frames are written before encoding: "png"
+	// (lossless) or "jpeg", which is smaller and faster to write.
+	// FrameQuality is the JPEG quality, 1 to 100.
+	FrameFormat  string `json:"frameFormat"`
+	FrameQuality int    `json:"frameQuality"`
+	// Fit is how re-encoded clips of another shape reach the frame size:
+	// "stretch" scales to it, "pad" letterboxes with PadColor, and "crop"
+	// fills it and trims the overflow. It applies to normalized clips and
+	// crossfades.
+	Fit      string `json:"fit"`
+	PadColor string `json:"padColor"`
+	// ConcatMode is "copy" (stream copy), "reencode", "auto" (stream
+	// copy, re-encoding if the output duration doesn't match the inputs),
+	// or "fast": probe the sources and, when they all share a codec, size,
+	// frame rate, and audio format, encode the cards to match and stream
+	// copy; otherwise re-encode. The sources are probed before any
+	// preProcess hook runs.
+	ConcatMode string `json:"concatMode"`
+	// ConcatMethod is how cards and clips are joined: "demuxer" (ffmpeg's
+	// concat demuxer, under ConcatMode) or "filter" (the concat filter,
+	// which always re-encodes but copes with mixed codecs and keeps audio
+	// in sync).
+	ConcatMethod string `json:"concatMethod"`
+	// TransitionType is "card" (a generated text clip between videos) or
+	// "xfade" (a crossfade, which always re-encodes the whole output).
+	TransitionType string      `json:"transitionType"`
+	Xfade          XfadeConfig `json:"xfade"`
+	// OutputFormat is the container: mp4, webm, or gif. Empty infers it
+	// from the output extension. VideoCodec and AudioCodec override the
+	// container's default encoders.
+	OutputFormat string `json:"outputFormat"`
+	VideoCodec   string `json:"videoCodec"`
+	AudioCodec   string `json:"audioCodec"`
+	// HardwareEncoder encodes cards and trimmed and normalized clips with
+	// a hardware H.264 encoder: "h264_nvenc", "h264_videotoolbox", or
+	// "h264_qsv". Sources are decoded with the matching -hwaccel. If ffmpeg
+	// lacks the encoder, the merge warns and uses libx264. Empty uses the
+	// software encoder.
+	HardwareEncoder string `json:"hardwareEncoder"`
+	// FFmpegArgs are inserted verbatim into each card's encode, and
+	// ConcatArgs into the final concat, after the codec arguments and
+	// before the output path, e.g. ["-crf", "18", "-preset", "slow"].
+	FFmpegArgs []string `json:"ffmpegArgs"`
+	ConcatArgs []string `json:"concatArgs"`
+	// MaxDuration caps the output at this many seconds, cutting whatever
+	// runs past it, with a warning saying how much; 0 leaves the length
+	// alone. Music, chapters, and subtitles end at the cut.
+	MaxDuration float64 `json:"maxDuration"`
+	// PreProcess and PostProcess are commands run on each source clip, as
+	// a program followed by its arguments, with {input} and {output}
+	// replaced by file paths. PreProcess gets the clip as found, before
+	// trimming and normalizing; PostProcess gets it after, just before
+	// the concat. The merge uses each command's output in place of its
+	// input. Commands aren't run through a shell, so use e.g.
+	// ["sh", "-c", "...", "--", "{input}", "{output}"] for shell syntax.
+	PreProcess  []string     `json:"preProcess"`
+	PostProcess []string     `json:"postProcess"`
+	Audio       AudioConfig  `json:"audio"`
+	Logo        LogoConfig   `json:"logo"`
+	Music       MusicConfig  `json:"music"`
+	Poster      PosterConfig `json:"poster"`
+	// Chapters adds a chapter per source clip to the output, titled with
+	// its caption.
+	Chapters  bool            `json:"chapters"`
+	Subtitles SubtitlesConfig `json:"subtitles"`
+	// PreserveMetadata copies the global metadata of a source clip, such
+	// as its creation time, location, and title, onto the output, which a
+	// concat otherwise drops. MetadataSource picks the clip by path, base
+	// name, or index in the merge order; empty means the first.
+	PreserveMetadata bool           `json:"preserveMetadata"`
+	MetadataSource   string         `json:"metadataSource"`
+	Metadata         MetadataConfig `json:"metadata"`
+	// CacheTransitions keeps encoded cards in the intermediate dir and
+	// reuses them in later runs when nothing that affects them changed.
+	CacheTransitions bool `json:"cacheTransitions"`
+	// EncodeWorkers bounds how many cards are encoded at once; 0 means
+	// half of runtime.NumCPU().
+	EncodeWorkers int `json:"encodeWorkers"`
+	// Threads caps the threads each ffmpeg encode uses, passed as
+	// -threads. Cards are encoded EncodeWorkers at a time, each with up to
+	// Threads threads. 0 leaves it to ffmpeg.
+	Threads int `json:"threads"`
+	// SkipDiskCheck skips the check, made once inputs are trimmed, that
+	// fails a run when the intermediate dir's volume looks too full for
+	// its frames and clips.
+	SkipDiskCheck bool `json:"skipDiskCheck"`
+	// MaxCardFrames stops a run before any frame is drawn when a card
+	// would have more frames than this, which usually means a typo in the
+	// frame rate or a duration. Set Force to proceed anyway. 0 disables
+	// the check.
+	MaxCardFrames int        `json:"maxCardFrames"`
+	Intro         CardConfig `json:"intro"`
+	Outro         CardConfig `json:"outro"`
+	// Bumper inserts a short clip, such as a channel ident, between
+	// sections of the merge. It's independent of the cards.
+	Bumper BumperConfig `json:"bumper"`
+	TOC    TOCConfig    `json:"tableOfContents"`
+	Retry  RetryConfig  `json:"retry"`
+	// LogFile, when set, is appended a timestamped record of each merge:
+	// its messages, how long each step took, and the result or error.
+	LogFile string `json:"logFile"`
+	// FFmpegPath, FFprobePath, and AWSPath override the binaries looked
+	// up on PATH. The AWS CLI is only needed for s3:// sources.
+	FFmpegPath  string `json:"ffmpegPath"`
+	FFprobePath string `json:"ffprobePath"`
+	AWSPath     string `json:"awsPath"`
+
+	// Progress, when set, is called as transitions are built and as the
+	// final merge encodes.
+	Progress ProgressFunc `json:"-"`
+	// Logger controls messages and ffmpeg output. Nil logs high-level
+	// steps to stderr.
+	Logger *Logger `json:"-"`
+	// KeepIntermediates leaves the run's frames, clips, and filelist on
+	// disk for debugging instead of removing them.
+	KeepIntermediates bool `json:"-"`
+	// Force skips the MaxCardFrames check.
+	Force bool `json:"-"`
+	// Runner, when set, runs every ffmpeg and ffprobe command in place of
+	// executing it directly.
+	Runner Runner `json:"-"`
+	// Metrics, when set, records each merge and ffmpeg run.
+	Metrics *Metrics `json:"-"`
+
+	probes *probeCache
+	hashes *hashCache
+	ctx    context.Context
+}
+
+// SourceEntry is one source video. Start and End are ffmpeg-style times
+// ("00:01:05", "1:05.5", or seconds); either may be empty to keep the
+// clip's beginning or end. Trimmed clips are re-encoded. Transition set
+// to false drops the card before the clip, so it follows the previous one
+// directly.
+type SourceEntry struct {
+	Path       string `json:"path"`
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Transition *bool  `json:"transition,omitempty"`
+	// Style names the entry in Config.Styles for the card before this
+	// clip.
+	Style string `json:"style,omitempty"`
+}
+
+// trimmed reports whether only part of the clip is used.
+func (e SourceEntry) trimmed() bool {
+	return e.Start != "" || e.End != ""
+}
+
+// UnmarshalJSON accepts either a plain path string or an object.
+func (e *SourceEntry) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*e = SourceEntry{}
+		return json.Unmarshal(data, &e.Path)
+	}
+	// The alias drops this method so the object decodes normally.
+	type entry SourceEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*entry)(e))
+}
+
+// MarshalJSON writes untrimmed entries back as plain paths.
+func (e SourceEntry) MarshalJSON() ([]byte, error) {
+	if !e.trimmed() && e.Transition == nil && e.Style == "" {
+		return json.Marshal(e.Path)
+	}
+	type entry SourceEntry
+	return json.Marshal(entry(e))
+}
+
+// SourcePaths converts plain paths into source entries.
+func SourcePaths(paths []string) []SourceEntry {
+	entries := make([]SourceEntry, len(paths))
+	for i, path := range paths {
+		entries[i] = SourceEntry{Path: path}
+	}
+	return entries
+}
+
+// paths returns the path of each source entry.
+func paths(sources []SourceEntry) []string {
+	videos := make([]string, len(sources))
+	for i, source := range sources {
+		videos[i] = source.Path
+	}
+	return videos
+}
+
+type Destination struct {
+	// Output is the merged video's path. "-" writes it to stdout, and a
+	// named pipe is written to as a stream, with mp4 fragmented so it
+	// plays without seeking. Neither gets a poster.
+	Output string `json:"output"`
+	// IntermediateTextDir holds each run's frames and clips, each run in
+	// a subdirectory of its own that's removed when the merge finishes.
+	IntermediateTextDir string `json:"intermediateTextDir"`
+}
+
+type ScanConfig struct {
+	Recursive bool `json:"recursive"`
+	// SortBy is "name" (ordered by SortMode), "modtime", or "ctime" (the
+	// inode change time, or modtime where the platform lacks one). Order
+	// is "asc" or "desc".
+	SortBy   string `json:"sortBy"`
+	SortMode string `json:"sortMode"`
+	Order    string `json:"order"`
+	// Since and Until keep only scanned videos modified in that range, as
+	// RFC 3339 times or YYYY-MM-DD dates in local time; an Until date
+	// includes the whole day. Either may be empty to leave that end open.
+	Since string `json:"since"`
+	Until string `json:"until"`
+}
+
+const (
+	ConcatCopy     = "copy"
+	ConcatReencode = "reencode"
+	ConcatAuto     = "auto"
+	ConcatFast     = "fast"
+)
+
+const (
+	ConcatMethodDemuxer = "demuxer"
+	ConcatMethodFilter  = "filter"
+)
+
+const (
+	DedupeByPath    = "path"
+	DedupeByContent = "content"
+)
+
+const (
+	FitStretch = "stretch"
+	FitPad     = "pad"
+	FitCrop    = "crop"
+)
+
+const (
+	TransitionCard  = "card"
+	TransitionXfade = "xfade"
+)
+
+const (
+	AlignLeft   = "left"
+	AlignCenter = "center"
+	AlignRight  = "right"
+	AlignTop    = "top"
+	AlignMiddle = "middle"
+	AlignBottom = "bottom"
+)
+
+const (
+	FrameFormatPNG  = "png"
+	FrameFormatJPEG = "jpeg"
+)
+
+const (
+	FrameAutoFirst   = "first"
+	FrameAutoLargest = "largest"
+)
+
+const (
+	AnimationNone      = "none"
+	AnimationSlideLeft = "slideLeft"
+	AnimationSlideUp   = "slideUp"
+	AnimationZoom      = "zoom"
+)
+
+const (
+	SortNatural = "natural"
+	SortLexical = "lexical"
+	SortNone    = "none"
+)
+
+const (
+	SortByName    = "name"
+	SortByModTime = "modtime"
+	SortByCTime   = "ctime"
+)
+
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// XfadeConfig sets the crossfade used when TransitionType is "xfade".
+// Style is any ffmpeg xfade transition, such as fade, wipeleft, or
+// dissolve. Duration is in seconds.
+type XfadeConfig struct {
+	Style    string  `json:"style"`
+	Duration float64 `json:"duration"`
+}
+
+// AudioConfig sets the silent track added to transition clips. Zero values
+// are filled from the first source video's audio stream.
+type AudioConfig struct {
+	SampleRate    int    `json:"sampleRate"`
+	ChannelLayout string `json:"channelLayout"`
+}
+
+// LogoConfig places an image on every transition frame. Position is one of
+// top-left, top, top-right, left, center, right, bottom-left, bottom, or
+// bottom-right. An empty Path disables the logo.
+type LogoConfig struct {
+	Path     string  `json:"path"`
+	Position string  `json:"position"`
+	Margin   int     `json:"margin"`
+	Opacity  float64 `json:"opacity"`
+}
+
+// MusicConfig adds a music track to the merged video in a final pass.
+// Mode "mix" plays it under the clips' audio, ducked beneath it when Duck
+// is set; "replace" discards the clips' audio. Loop repeats music shorter
+// than the video. An empty Path disables it.
+type MusicConfig struct {
+	Path   string  `json:"path"`
+	Volume float64 `json:"volume"`
+	Loop   bool    `json:"loop"`
+	Mode   string  `json:"mode"`
+	Duck   bool    `json:"duck"`
+}
+
+// PosterConfig saves a JPEG frame of the merged video beside it. At is a
+// percentage of the output ("50%") or a time ("00:01:30").
+type PosterConfig struct {
+	Enabled bool   `json:"enabled"`
+	At      string `json:"at"`
+}
+
+// SubtitlesConfig adds a subtitle track to the output that shows each
+// clip's caption for Duration seconds as the clip starts, as a lighter
+// alternative to (or alongside) the cards. Mode "soft" muxes the track in
+// so viewers can toggle it in their player.
+type SubtitlesConfig struct {
+	Enabled  bool    `json:"enabled"`
+	Mode     string  `json:"mode"`
+	Duration float64 `json:"duration"`
+}
+
+// MetadataConfig sets output metadata tags, taking precedence over any
+// preserved from a source clip. Empty fields are left alone.
+type MetadataConfig struct {
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	Comment string `json:"comment"`
+}
+
+// RetryConfig retries transition encodes and the concat when ffmpeg exits
+// with an error, up to Count more times. Backoff is the first delay in
+// seconds; it doubles after each retry.
+type RetryConfig struct {
+	Count   int     `json:"count"`
+	Backoff float64 `json:"backoff"`
+}
+
+// CardConfig describes an intro or outro card. An empty Text disables it.
+type CardConfig struct {
+	Text     string `json:"text"`
+	Duration int    `json:"duration"`
+}
+
+// BumperConfig plays the clip at Path after every EveryN source clips,
+// ahead of the card that introduces the next one. It's re-encoded to the
+// frame size, rate, and codecs of the cards first, so it joins like any
+// other segment. An empty Path disables it.
+type BumperConfig struct {
+	Path   string `json:"path"`
+	EveryN int    `json:"everyN"`
+}
+
+// PreviewConfig sets the card background taken from the next clip. Dim
+// darkens the frame so the caption stands out, from 0 (as is) to 1
+// (black). A clip whose frame can't be extracted gets the configured
+// background instead.
+type PreviewConfig struct {
+	Enabled bool    `json:"enabled"`
+	Dim     float64 `json:"dim"`
+}
+
+// TOCConfig adds table of contents cards after the intro, listing every
+// clip's caption in merge order, PerPage to a card shown for Duration
+// seconds. A page too long for the frame is drawn in a smaller font.
+type TOCConfig struct {
+	Enabled  bool `json:"enabled"`
+	Duration int  `json:"duration"`
+	PerPage  int  `json:"perPage"`
+}
+
+type FontConfig struct {
+	// Path is a TrueType font file. Empty uses the built-in Go Regular.
+	Path string  `json:"path"`
+	Size float64 `json:"size"`
+	// Fallbacks are fonts tried in order for characters Path has no glyph
+	// for, such as a CJK or Arabic font behind a Latin one.
+	Fallbacks []string `json:"fallbacks"`
+}
+
+type FrameConfig struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Rate   int `json:"rate"`
+	// Workers bounds concurrent frame rendering; 0 means runtime.NumCPU().
+	Workers int `json:"workers"`
+	// Supersample renders card frames at this multiple of the frame size,
+	// such as 2 or 4, and shrinks them to it, for smoother text at small
+	// sizes. 0 and 1 render at the frame size.
+	Supersample int `json:"supersample"`
+	// Auto takes Width, Height, and Rate from a source video with ffprobe
+	// instead: "first" (the first video in merge order) or "largest" (the
+	// video with the most pixels). Empty uses the configured values.
+	Auto string `json:"auto"`
+}
+
+// CardStyle overrides parts of the font and text config for one card.
+// Empty fields keep the configured value. Background only shows when the
+// text config has no background image or gradient.
+type CardStyle struct {
+	Background    string `json:"background"`
+	TextAnimation string `json:"textAnimation"`
+	Color         string `json:"color"`
+	// FontPath replaces the font, keeping the configured fallbacks, and
+	// FontSize its size.
+	FontPath string  `json:"fontPath"`
+	FontSize float64 `json:"fontSize"`
+}
+
+type TextConfig struct {
+	// Caption is the transition text template. It may use {index},
+	// {total}, {filename}, {basename}, {modtime}, and {duration}. Empty
+	// means DefaultCaption.
+	Caption string `json:"caption"`
+	// Color, Background, and the other colors in the config are hex
+	// ("#ffffff", "#fff8"), rgb()/rgba() ("rgba(0, 0, 0, 0.5)"), or CSS
+	// color names ("white", "navy").
+	Color      string `json:"color"`
+	Background string `json:"background"`
+	Duration   int    `json:"duration"`
+	// Durations overrides Duration for the transition before a given
+	// video, keyed by its path, base name, or index in the merge order.
+	Durations map[string]int `json:"durations"`
+	// NoTransitionBefore lists videos, by the same keys as Durations, that
+	// follow the previous one without a card.
+	NoTransitionBefore []string `json:"noTransitionBefore"`
+	// FadeInFrames and FadeOutFrames ramp the caption's opacity at the
+	// start and end of each transition. The background stays opaque.
+	FadeInFrames  int `json:"fadeInFrames"`
+	FadeOutFrames int `json:"fadeOutFrames"`
+	// MaxWidthFraction is the widest a caption line may be, as a fraction
+	// of the frame width, before it wraps.
+	MaxWidthFraction float64 `json:"maxWidthFraction"`
+	// HAlign is left, center, or right; VAlign is top, middle, or bottom.
+	// Margin keeps the caption that many pixels from the frame edges.
+	HAlign string `json:"hAlign"`
+	VAlign string `json:"vAlign"`
+	Margin int    `json:"margin"`
+	// TextX and TextY place the caption at an exact point instead: HAlign
+	// picks which side of it sits at TextX, and VAlign whether its top,
+	// middle, or bottom sits at TextY. Null keeps the alignment's spot.
+	TextX *Position `json:"textX"`
+	TextY *Position `json:"textY"`
+	// RTL lays out right-to-left captions (Arabic, Hebrew): lines are
+	// wrapped in reading order, then drawn reversed with embedded
+	// left-to-right runs such as numbers kept intact. Pair it with hAlign
+	// "right" for right-aligned text.
+	RTL bool `json:"rtl"`
+	// BackgroundImage, or failing that Gradient, replaces the solid
+	// Background color.
+	BackgroundImage string         `json:"backgroundImage"`
+	Gradient        GradientConfig `json:"gradient"`
+	// ShowProgressBar draws a bar along the bottom of each card showing
+	// how many of the source videos have played.
+	ShowProgressBar bool `json:"showProgressBar"`
+	// TextAnimation brings the caption in over the first half of each
+	// card: "none", "slideLeft" (in from the left), "slideUp" (up from
+	// the bottom), or "zoom" (grown from its center).
+	TextAnimation string `json:"textAnimation"`
+	// Stroke and Shadow help the caption stand out from the background.
+	Stroke StrokeConfig `json:"stroke"`
+	Shadow ShadowConfig `json:"shadow"`
+}
+
+// Position is a coordinate within the frame. In JSON a number is a
+// fraction of the frame's width or height, from 0 to 1, and a string such
+// as "120px" is a distance in pixels from the left or top edge.
+type Position struct {
+	Value  float64
+	Pixels bool
+}
+
+// resolve returns the position in pixels along a frame side of length
+// size.
+func (p Position) resolve(size float64) float64 {
+	if p.Pixels {
+		return p.Value
+	}
+	return p.Value * size
+}
+
+func (p *Position) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		px, ok := strings.CutSuffix(strings.TrimSpace(s), "px")
+		v, err := strconv.ParseFloat(strings.TrimSpace(px), 64)
+		if !ok || err != nil {
+			return fmt.Errorf("position %q must be a fraction such as 0.3 or pixels such as \"120px\"", s)
+		}
+		*p = Position{Value: v, Pixels: true}
+		return nil
+	}
+	*p = Position{}
+	return json.Unmarshal(data, &p.Value)
+}
+
+func (p Position) MarshalJSON() ([]byte, error) {
+	if p.Pixels {
+		return json.Marshal(strconv.FormatFloat(p.Value, 'f', -1, 64) + "px")
+	}
+	return json.Marshal(p.Value)
+}
+
+// StrokeConfig outlines the caption Width pixels wide. An empty Color
+// disables it.
+type StrokeConfig struct {
+	Color string `json:"color"`
+	Width int    `json:"width"`
+}
+
+// ShadowConfig draws a drop shadow offset from the caption and blurred by
+// Blur pixels. An empty Color disables it.
+type ShadowConfig struct {
+	Color   string `json:"color"`
+	OffsetX int    `json:"offsetX"`
+	OffsetY int    `json:"offsetY"`
+	Blur    int    `json:"blur"`
+}
+
+// GradientConfig is a two-color linear gradient. Direction is "vertical"
+// (the default) or "horizontal". An empty From disables it.
+type GradientConfig struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Direction string `json:"direction"`
+}
+
+// Defaults for the settings a card can't be drawn without.
+const (
+	DefaultWidth        = 1280
+	DefaultHeight       = 720
+	DefaultRate         = 30
+	DefaultFontSize     = 64
+	DefaultTextColor    = "#FFFFFF"
+	DefaultBackground   = "#000000"
+	DefaultCardDuration = 3
+	// DefaultIntermediateDir is the directory in the system temp dir that
+	// runs work in when dest.intermediateTextDir is empty.
+	DefaultIntermediateDir = "video_merger"
+)
+
+// WithDefaults returns config with the zero values that would fail
+// validation or draw nothing replaced:
+//
+//   - frame.width and frame.height: DefaultWidth x DefaultHeight
+//   - frame.rate: DefaultRate
+//   - frameFormat: png, and frameQuality: 90
+//   - font.size: DefaultFontSize; an empty font.path draws with the
+//     built-in Go Regular font
+//   - intro.duration and outro.duration: DefaultCardDuration seconds
+//   - text.color: DefaultTextColor, white
+//   - text.background: DefaultBackground, black
+//   - text.maxWidthFraction: 0.9
+//   - text.hAlign and text.vAlign: center and middle
+//   - dest.intermediateTextDir: DefaultIntermediateDir in the system temp
+//     directory
+//   - logo.opacity and music.volume: 1
+//   - the durations, page size, style, anchor, and interval of the
+//     table of contents, subtitles, crossfade, logo, and bumper: the same
+//     as DefaultConfig
+//
+// A zero text.duration is kept, since it means no transition cards.
+// DecodeConfig and every merge apply WithDefaults, so a config with only
+// sources works.
+func (config Config) WithDefaults() Config {
+	if config.Frame.Width == 0 {
+		config.Frame.Width = DefaultWidth
+	}
+	if config.Frame.Height == 0 {
+		config.Frame.Height = DefaultHeight
+	}
+	if config.Frame.Rate == 0 {
+		config.Frame.Rate = DefaultRate
+	}
+	if config.Font.Size == 0 {
+		config.Font.Size = DefaultFontSize
+	}
+	if config.Text.Color == "" {
+		config.Text.Color = DefaultTextColor
+	}
+	if config.Text.Background == "" {
+		config.Text.Background = DefaultBackground
+	}
+	if config.Text.MaxWidthFraction == 0 {
+		config.Text.MaxWidthFraction = 0.9
+	}
+	if config.Intro.Duration == 0 {
+		config.Intro.Duration = DefaultCardDuration
+	}
+	if config.Outro.Duration == 0 {
+		config.Outro.Duration = DefaultCardDuration
+	}
+	if config.FrameFormat == "" {
+		config.FrameFormat = FrameFormatPNG
+	}
+	if config.FrameQuality == 0 {
+		config.FrameQuality = 90
+	}
+	if config.Text.HAlign == "" {
+		config.Text.HAlign = AlignCenter
+	}
+	if config.Text.VAlign == "" {
+		config.Text.VAlign = AlignMiddle
+	}
+	if config.Dest.IntermediateTextDir == "" {
+		config.Dest.IntermediateTextDir = filepath.Join(os.TempDir(), DefaultIntermediateDir)
+	}
+	if config.TOC.Duration == 0 {
+		config.TOC.Duration = 5
+	}
+	if config.TOC.PerPage == 0 {
+		config.TOC.PerPage = 10
+	}
+	if config.Subtitles.Duration == 0 {
+		config.Subtitles.Duration = 3
+	}
+	if config.Xfade.Style == "" {
+		config.Xfade.Style = "fade"
+	}
+	if config.Xfade.Duration == 0 {
+		config.Xfade.Duration = 1
+	}
+	if config.Logo.Position == "" {
+		config.Logo.Position = "bottom-right"
+	}
+	if config.Logo.Opacity == 0 {
+		config.Logo.Opacity = 1
+	}
+	if config.Music.Volume == 0 {
+		config.Music.Volume = 1
+	}
+	if config.Bumper.EveryN == 0 {
+		config.Bumper.EveryN = 5
+	}
+	return config
+}
+
+// DefaultConfig returns a Config with the defaults that differ from the
+// zero value. Decode a config file on top of it to keep them.
+func DefaultConfig() Config {
+	return Config{
+		ConcatMode:       ConcatCopy,
+		ConcatMethod:     ConcatMethodDemuxer,
+		Fit:              FitStretch,
+		DedupeBy:         DedupeByPath,
+		PadColor:         "#000000",
+		FrameFormat:      FrameFormatPNG,
+		FrameQuality:     90,
+		MaxCardFrames:    3000,
+		TransitionType:   TransitionCard,
+		Xfade:            XfadeConfig{Style: "fade", Duration: 1},
+		SourceDir:        DefaultSourceDir,
+		SourceExtensions: append([]string(nil), DefaultSourceExtensions...),
+		Scan:             ScanConfig{Recursive: true, SortBy: SortByName, SortMode: SortNatural, Order: OrderAsc},
+		Frame:            FrameConfig{Width: DefaultWidth, Height: DefaultHeight, Rate: DefaultRate},
+		Font:             FontConfig{Size: DefaultFontSize},
+		Text: TextConfig{Color: DefaultTextColor, Background: DefaultBackground, Duration: DefaultCardDuration,
+			MaxWidthFraction: 0.9, HAlign: AlignCenter, VAlign: AlignMiddle},
+		Intro:             CardConfig{Duration: DefaultCardDuration},
+		Outro:             CardConfig{Duration: DefaultCardDuration},
+		Bumper:            BumperConfig{EveryN: 5},
+		Logo:              LogoConfig{Position: "bottom-right", Opacity: 1},
+		Music:             MusicConfig{Volume: 1, Mode: MusicMix},
+		Retry:             RetryConfig{Backoff: 1},
+		Poster:            PosterConfig{At: "50%"},
+		Subtitles:         SubtitlesConfig{Mode: SubtitlesSoft, Duration: 3},
+		TOC:               TOCConfig{Duration: 5, PerPage: 10},
+		PreviewBackground: PreviewConfig{Dim: 0.5},
+	}
+}
+
+// DecodeConfig reads a JSON config on top of DefaultConfig. Unknown keys
+// are rejected so typos don't silently fall back to zero values.
+func DecodeConfig(r io.Reader) (Config, error) {
+	config := DefaultConfig()
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return Config{}, withKind(ErrConfigInvalid, err)
+	}
+	if config.Version == 0 {
+		config.Version = ConfigVersion
+	}
+	if config.Version != ConfigVersion {
+		return Config{}, withKind(ErrConfigInvalid, fmt.Errorf("unsupported config version %d (this build supports version %d)", config.Version, ConfigVersion))
+	}
+	return config.WithDefaults(), nil
+}
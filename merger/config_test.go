@@ -0,0 +1,57 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithDefaultsSourcesOnly(t *testing.T) {
+	dir := t.TempDir()
+	clip := filepath.Join(dir, "clip.mp4")
+	if err := os.WriteFile(clip, []byte("fake"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Source: []SourceEntry{{Path: clip}},
+		Dest:   Destination{Output: filepath.Join(dir, "out.mp4")},
+	}.WithDefaults()
+
+	if errs := ValidateConfig(config); len(errs) > 0 {
+		t.Fatalf("sources-only config failed validation: %v", errs)
+	}
+	if config.Logo.Opacity != 1 || config.Music.Volume != 1 {
+		t.Errorf("logo.opacity = %g, music.volume = %g; want 1 and 1", config.Logo.Opacity, config.Music.Volume)
+	}
+	if want := filepath.Join(os.TempDir(), DefaultIntermediateDir); config.Dest.IntermediateTextDir != want {
+		t.Errorf("dest.intermediateTextDir = %q, want %q", config.Dest.IntermediateTextDir, want)
+	}
+	if _, err := loadFonts(config.Font); err != nil {
+		t.Errorf("built-in font: %v", err)
+	}
+}
+
+func TestWithDefaultsKeepsZeroTextDuration(t *testing.T) {
+	// A zero text.duration turns the transition cards off.
+	if config := (Config{}).WithDefaults(); config.Text.Duration != 0 {
+		t.Errorf("text.duration = %d, want 0 kept", config.Text.Duration)
+	}
+}
+
+func TestWithDefaultsKeepsSetValues(t *testing.T) {
+	config := Config{
+		Frame:       FrameConfig{Width: 640, Height: 360, Rate: 24},
+		Text:        TextConfig{Duration: 5, Color: "red", HAlign: AlignLeft},
+		FrameFormat: FrameFormatJPEG,
+	}.WithDefaults()
+	if config.Frame != (FrameConfig{Width: 640, Height: 360, Rate: 24}) {
+		t.Errorf("frame = %+v, want 640x360 at 24", config.Frame)
+	}
+	if config.Text.Duration != 5 || config.Text.Color != "red" || config.Text.HAlign != AlignLeft {
+		t.Errorf("text = duration %d, color %q, hAlign %q; want 5, red, left",
+			config.Text.Duration, config.Text.Color, config.Text.HAlign)
+	}
+	if config.FrameFormat != FrameFormatJPEG {
+		t.Errorf("frameFormat = %q, want %q", config.FrameFormat, FrameFormatJPEG)
+	}
+}
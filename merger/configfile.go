@@ -0,0 +1,39 @@
+package merger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeConfigFile is DecodeConfig for a config read from a file named
+// name, choosing YAML (.yaml, .yml) or TOML (.toml) by its extension and
+// JSON otherwise. YAML and TOML use the same keys as JSON: they are
+// decoded generically and re-encoded as JSON, so the same defaults and
+// unknown-key checks apply.
+func DecodeConfigFile(r io.Reader, name string) (Config, error) {
+	var generic map[string]any
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		if err := yaml.NewDecoder(r).Decode(&generic); err != nil && err != io.EOF {
+			return Config{}, withKind(ErrConfigInvalid, err)
+		}
+	case ".toml":
+		if _, err := toml.NewDecoder(r).Decode(&generic); err != nil {
+			return Config{}, withKind(ErrConfigInvalid, err)
+		}
+	default:
+		return DecodeConfig(r)
+	}
+
+	data, err := json.Marshal(generic)
+	if err != nil {
+		return Config{}, err
+	}
+	return DecodeConfig(bytes.NewReader(data))
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package merger
+
+import (
+	"os"
+	"time"
+)
+
+// changeTime falls back to the modification time on platforms whose stat
+// doesn't expose a change time.
+func changeTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,70 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+)
+
+// Rough per-pixel sizes used to estimate the space a run needs. Card
+// frames are mostly flat color, so their PNGs compress well and JPEGs
+// better still; encoded card clips are far smaller again.
+const (
+	pngBytesPerPixel  = 0.25
+	jpegBytesPerPixel = 0.1
+	clipBytesPerPixel = 0.02
+)
+
+// estimateTempSpace returns the bytes a run's remaining intermediates may
+// take: the frames and clips of the cards still to be generated, plus a
+// copy of every input when the merge normalizes them.
+func estimateTempSpace(config Config, plan mergePlan, inputs []string, normalize bool) int64 {
+	pixels := float64(config.Frame.Width * config.Frame.Height)
+	var frames, images int
+	for _, c := range plan.pending() {
+		frames += c.frames
+		images += c.images()
+	}
+	frameBytes := pngBytesPerPixel
+	if config.FrameFormat == FrameFormatJPEG {
+		frameBytes = jpegBytesPerPixel
+	}
+	total := int64(pixels * (float64(images)*frameBytes + float64(frames)*clipBytesPerPixel))
+
+	if !normalize {
+		return total
+	}
+	for _, input := range inputs {
+		if info, err := os.Stat(input); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// checkDiskSpace fails if dir's volume has less free space than the run
+// is estimated to need. Platforms without a free-space query pass.
+func checkDiskSpace(config Config, dir string, plan mergePlan, inputs []string, normalize bool) error {
+	available, ok := freeSpace(dir)
+	if !ok {
+		return nil
+	}
+	need := estimateTempSpace(config, plan, inputs, normalize)
+	if uint64(need) > available {
+		return fmt.Errorf("not enough disk space in '%s': need about %s, %s available (set skipDiskCheck to bypass)",
+			dir, formatBytes(uint64(need)), formatBytes(available))
+	}
+	return nil
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
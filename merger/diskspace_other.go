@@ -0,0 +1,9 @@
+//go:build !unix
+
+package merger
+
+// freeSpace can't query free space on this platform, so the check is
+// skipped.
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
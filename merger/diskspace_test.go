@@ -0,0 +1,27 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateTempSpaceNormalizedCopies(t *testing.T) {
+	dir := t.TempDir()
+	var inputs []string
+	for _, name := range []string{"trimmed_0.mp4", "clip2.mp4"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("fake"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, path)
+	}
+	config := DefaultConfig()
+	// No cards, so only the 4 byte inputs' normalized copies count.
+	if got := estimateTempSpace(config, mergePlan{}, inputs, false); got != 0 {
+		t.Errorf("estimate without normalizing = %d, want 0", got)
+	}
+	if got := estimateTempSpace(config, mergePlan{}, inputs, true); got != 8 {
+		t.Errorf("estimate normalizing = %d, want a copy of each input, 8", got)
+	}
+}
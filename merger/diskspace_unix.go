@@ -0,0 +1,15 @@
+//go:build unix
+
+package merger
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// volume holding dir.
+func freeSpace(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
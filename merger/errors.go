@@ -0,0 +1,50 @@
+package merger
+
+import "errors"
+
+// Errors returned by this package wrap one of these kinds alongside the
+// underlying cause, so callers can react to the kind of failure with
+// errors.Is, e.g. to tell a missing ffmpeg install from a bad config.
+var (
+	// ErrConfigInvalid marks a config that can't be decoded or fails
+	// validation.
+	ErrConfigInvalid = errors.New("invalid config")
+	// ErrNoSources marks a merge with no videos to merge, or with source
+	// videos that can't be found.
+	ErrNoSources = errors.New("no source videos")
+	// ErrFontLoad marks a font or fallback font that can't be read or
+	// parsed.
+	ErrFontLoad = errors.New("font could not be loaded")
+	// ErrFFmpeg marks ffmpeg or ffprobe missing, failing to start, or
+	// exiting with an error.
+	ErrFFmpeg = errors.New("ffmpeg failed")
+	// ErrRemote marks the AWS CLI missing, or failing to check or fetch
+	// an s3:// source.
+	ErrRemote = errors.New("remote source failed")
+	// ErrTooManyFrames marks a merge stopped because a card would have
+	// more than MaxCardFrames frames.
+	ErrTooManyFrames = errors.New("too many frames")
+)
+
+// Error is a failure of one of the kinds above. Its message is the
+// cause's, and errors.Is and errors.As see both Kind and Err.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// withKind tags err with kind, passing nil through.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
@@ -0,0 +1,36 @@
+package merger
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// failingRunner fails every command as if it exited nonzero.
+type failingRunner struct{}
+
+func (failingRunner) Run(cmd *exec.Cmd) error {
+	return exec.Command("false").Run()
+}
+
+func TestRunErrorKinds(t *testing.T) {
+	config := DefaultConfig()
+	config.Runner = failingRunner{}
+	if err := config.run(config.ffmpegCommand("-version")); !errors.Is(err, ErrFFmpeg) {
+		t.Errorf("ffmpeg failure = %v, want ErrFFmpeg", err)
+	}
+	err := config.run(config.awsCommand("s3", "cp", "s3://bucket/clip.mp4", "clip.mp4"))
+	if !errors.Is(err, ErrRemote) || errors.Is(err, ErrFFmpeg) {
+		t.Errorf("aws failure = %v, want only ErrRemote", err)
+	}
+}
+
+func TestMissingSourcesKind(t *testing.T) {
+	config := testConfig(t, &fakeRunner{}, 1)
+	config.Source = append(config.Source, SourceEntry{Path: filepath.Join(t.TempDir(), "missing.mp4")})
+	_, err := Merge(config)
+	if !errors.Is(err, ErrNoSources) || errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("Merge with a missing source = %v, want only ErrNoSources", err)
+	}
+}
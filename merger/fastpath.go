@@ -0,0 +1,195 @@
+package merger
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// streamFormat is what a clip's streams must share with every other clip,
+// and with the cards, for the concat demuxer to stream copy them.
+type streamFormat struct {
+	videoCodec    string
+	profile       string
+	pixFmt        string
+	width, height int
+	rate          float64
+	audioCodec    string
+	sampleRate    string
+	channelLayout string
+}
+
+func (f streamFormat) String() string {
+	s := f.videoCodec
+	if f.profile != "" {
+		s += " (" + f.profile + ")"
+	}
+	s += fmt.Sprintf(" %s %dx%d at %g fps", f.pixFmt, f.width, f.height, f.rate)
+	if f.audioCodec != "" {
+		s += fmt.Sprintf(", %s %sHz %s", f.audioCodec, f.sampleRate, f.channelLayout)
+	}
+	return s
+}
+
+func (f streamFormat) matches(o streamFormat) bool {
+	diff := math.Abs(f.rate - o.rate)
+	f.rate, o.rate = 0, 0
+	return f == o && diff <= 0.01
+}
+
+func probeStreamFormat(config Config, path string) (streamFormat, error) {
+	out, err := config.runProbe("-v", "error", "-show_entries",
+		"stream=codec_type,codec_name,profile,pix_fmt,width,height,r_frame_rate,sample_rate,channel_layout", "-of", "json", path)
+	if err != nil {
+		return streamFormat{}, fmt.Errorf("error probing '%s': %w", path, err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecType     string `json:"codec_type"`
+			CodecName     string `json:"codec_name"`
+			Profile       string `json:"profile"`
+			PixFmt        string `json:"pix_fmt"`
+			Width         int    `json:"width"`
+			Height        int    `json:"height"`
+			RFrameRate    string `json:"r_frame_rate"`
+			SampleRate    string `json:"sample_rate"`
+			ChannelLayout string `json:"channel_layout"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return streamFormat{}, fmt.Errorf("error parsing ffprobe output for '%s': %w", path, err)
+	}
+
+	var f streamFormat
+	var video, audio bool
+	for _, st := range probe.Streams {
+		switch {
+		case st.CodecType == "video" && !video:
+			video = true
+			f.videoCodec, f.profile, f.pixFmt = st.CodecName, st.Profile, st.PixFmt
+			f.width, f.height, f.rate = st.Width, st.Height, parseFrameRate(st.RFrameRate)
+		case st.CodecType == "audio" && !audio:
+			audio = true
+			f.audioCodec, f.sampleRate, f.channelLayout = st.CodecName, st.SampleRate, st.ChannelLayout
+		}
+	}
+	if !video {
+		return streamFormat{}, fmt.Errorf("no video stream in '%s'", path)
+	}
+	return f, nil
+}
+
+// probedAudioCodecNames maps audio encoders to the codec name ffprobe
+// reports for their output.
+var probedAudioCodecNames = map[string]string{
+	"aac":        "aac",
+	"libmp3lame": "mp3",
+	"libopus":    "opus",
+	"libvorbis":  "vorbis",
+}
+
+// encoderProfiles maps the H.264 and HEVC profiles ffprobe reports to the
+// -profile:v value that makes the encoder produce them.
+var encoderProfiles = map[string]string{
+	"Constrained Baseline": "baseline",
+	"Baseline":             "baseline",
+	"Main":                 "main",
+	"High":                 "high",
+}
+
+// encoderFor returns the encoder in codecs whose output ffprobe reports
+// as name.
+func encoderFor(codecs []string, probed map[string]string, name string) (string, bool) {
+	for _, codec := range codecs {
+		if probed[codec] == name {
+			return codec, true
+		}
+	}
+	return "", false
+}
+
+// resolveFastPath decides how concat mode "fast" joins videos. When every
+// clip shares one stream format that the cards can be encoded in, it
+// returns config set up to encode the cards in that format and stream copy
+// everything; otherwise it returns config set to re-encode. Either way it
+// logs which path was chosen and why.
+func resolveFastPath(config Config, videos []string) (Config, error) {
+	reason, err := fastPathBlocker(config, videos)
+	if err != nil {
+		return Config{}, err
+	}
+	if reason != "" {
+		config.logger().Infof("Fast path: %s; re-encoding", reason)
+		config.ConcatMode = ConcatReencode
+		return config, nil
+	}
+
+	f, err := probeStreamFormat(config, videos[0])
+	if err != nil {
+		return Config{}, err
+	}
+	format := config.intermediateFormat()
+	config.VideoCodec, _ = encoderFor(formats[format].videoCodecs, probedCodecNames, f.videoCodec)
+	config.AudioCodec, _ = encoderFor(formats[format].audioCodecs, probedAudioCodecNames, f.audioCodec)
+	if profile, ok := encoderProfiles[f.profile]; ok && (f.videoCodec == "h264" || f.videoCodec == "hevc") {
+		config.FFmpegArgs = append(slices.Clone(config.FFmpegArgs), "-profile:v", profile)
+	}
+	config.Frame.Width, config.Frame.Height = f.width, f.height
+	config.Frame.Rate = int(math.Round(f.rate))
+	config.Audio.SampleRate, _ = strconv.Atoi(f.sampleRate)
+	config.Audio.ChannelLayout = f.channelLayout
+	config.ConcatMode = ConcatCopy
+	config.logger().Infof("Fast path: all %d clips are %s; encoding cards to match and stream copying", len(videos), f)
+	return config, nil
+}
+
+// fastPathBlocker returns why videos can't be joined by stream copy, or
+// "" when they can.
+func fastPathBlocker(config Config, videos []string) (string, error) {
+	format := config.intermediateFormat()
+	switch {
+	case config.outputFormat() != format:
+		return fmt.Sprintf("%s output is always re-encoded", config.outputFormat()), nil
+	case config.TransitionType == TransitionXfade:
+		return "crossfades are always re-encoded", nil
+	case config.ConcatMethod == ConcatMethodFilter:
+		return "the concat filter always re-encodes", nil
+	case config.HardwareEncoder != "":
+		return "hardwareEncoder can't match the sources' encoding", nil
+	}
+
+	first, err := probeStreamFormat(config, videos[0])
+	if err != nil {
+		return "", err
+	}
+	for _, video := range videos[1:] {
+		f, err := probeStreamFormat(config, video)
+		if err != nil {
+			return "", err
+		}
+		if !f.matches(first) {
+			return fmt.Sprintf("'%s' is %s but '%s' is %s", video, f, videos[0], first), nil
+		}
+	}
+
+	switch {
+	case first.audioCodec == "":
+		return "the clips have no audio, but cards do", nil
+	case first.pixFmt != "yuv420p":
+		return fmt.Sprintf("cards are encoded as yuv420p, not %s", first.pixFmt), nil
+	case math.Abs(first.rate-math.Round(first.rate)) > 0.01:
+		return fmt.Sprintf("cards can't be encoded at %g fps", first.rate), nil
+	case config.OutputRate > 0 && float64(config.OutputRate) != math.Round(first.rate):
+		return fmt.Sprintf("outputRate %d differs from the clips' %g fps", config.OutputRate, first.rate), nil
+	}
+	if _, ok := encoderFor(formats[format].videoCodecs, probedCodecNames, first.videoCodec); !ok {
+		return fmt.Sprintf("%s video can't be written to %s", first.videoCodec, format), nil
+	}
+	if _, ok := encoderFor(formats[format].audioCodecs, probedAudioCodecNames, first.audioCodec); !ok {
+		return fmt.Sprintf("%s audio can't be written to %s", first.audioCodec, format), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,222 @@
+package merger
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+// fontChain is the caption font followed by its fallbacks, parsed once
+// and shared by every frame worker.
+type fontChain []*truetype.Font
+
+// loadFonts parses the configured font and its fallbacks. An empty path
+// is the built-in Go Regular font.
+func loadFonts(config FontConfig) (fontChain, error) {
+	var fonts fontChain
+	for _, path := range append([]string{config.Path}, config.Fallbacks...) {
+		data := goregular.TTF
+		if path != "" {
+			var err error
+			if data, err = os.ReadFile(path); err != nil {
+				return nil, withKind(ErrFontLoad, fmt.Errorf("error loading font from path '%s': %w", path, err))
+			}
+		}
+		f, err := truetype.Parse(data)
+		if err != nil {
+			return nil, withKind(ErrFontLoad, fmt.Errorf("error loading font from path '%s': %w", path, err))
+		}
+		fonts = append(fonts, f)
+	}
+	return fonts, nil
+}
+
+// face returns a face at size that draws each rune with the first font
+// in the chain that has a glyph for it. Faces cache glyphs, so each
+// worker needs its own.
+func (fc fontChain) face(size float64) font.Face {
+	faces := make([]font.Face, len(fc))
+	for i, f := range fc {
+		faces[i] = truetype.NewFace(f, &truetype.Options{Size: size})
+	}
+	if len(faces) == 1 {
+		return faces[0]
+	}
+	return &fallbackFace{fonts: fc, faces: faces}
+}
+
+// missing returns the runes of text, in order of appearance, that no font
+// in the chain has a glyph for. Spaces and control characters are never
+// reported.
+func (fc fontChain) missing(text string) []rune {
+	var runes []rune
+	for _, r := range text {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || slices.Contains(runes, r) {
+			continue
+		}
+		if fc.index(r) < 0 {
+			runes = append(runes, r)
+		}
+	}
+	return runes
+}
+
+// index returns the position of the first font with a glyph for r, or -1.
+func (fc fontChain) index(r rune) int {
+	for i, f := range fc {
+		if f.Index(r) != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// warnMissingGlyphs logs each distinct card text that uses characters
+// none of the fonts can draw, which would otherwise render as empty boxes.
+func warnMissingGlyphs(config Config, fonts fontChain, cards []card) {
+	warned := map[string]bool{}
+	for _, c := range cards {
+		if warned[c.text] {
+			continue
+		}
+		warned[c.text] = true
+		if runes := fonts.missing(c.text); len(runes) > 0 {
+			config.logger().Infof("Warning: no configured font has glyphs for %q in %q; add a font.fallbacks entry that covers them",
+				string(runes), c.text)
+		}
+	}
+}
+
+// fallbackFace is a font.Face over a font chain. Line metrics come from
+// the primary font.
+type fallbackFace struct {
+	fonts fontChain
+	faces []font.Face
+}
+
+// pick returns the face that draws r, falling back to the primary face's
+// missing glyph when no font has one.
+func (f *fallbackFace) pick(r rune) font.Face {
+	if i := f.fonts.index(r); i >= 0 {
+		return f.faces[i]
+	}
+	return f.faces[0]
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.pick(r).Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.pick(r).GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.pick(r).GlyphAdvance(r)
+}
+
+// Kern only applies between glyphs of the same font.
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := f.pick(r0)
+	if face != f.pick(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+// isRTL reports whether r is a strong right-to-left character.
+func isRTL(r rune) bool {
+	return unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko)
+}
+
+// mirrored maps paired punctuation to its mirror image for RTL lines.
+var mirrored = map[rune]rune{'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{', '<': '>', '>': '<', '«': '»', '»': '«'}
+
+// visualOrder reorders a right-to-left line for drawing left to right.
+// Runs of left-to-right text, such as numbers and Latin words, keep their
+// order; everything else is reversed, with combining marks kept on their
+// base character and paired punctuation mirrored. This is a simplified
+// form of the Unicode bidirectional algorithm: it doesn't shape Arabic
+// letters, so the font (or pre-shaped caption text) must provide their
+// joined forms.
+func visualOrder(line string) string {
+	// Split into clusters of a base character and its combining marks.
+	var clusters []string
+	for _, r := range line {
+		if len(clusters) > 0 && unicode.Is(unicode.Mn, r) {
+			clusters[len(clusters)-1] += string(r)
+			continue
+		}
+		clusters = append(clusters, string(r))
+	}
+
+	// Classify each cluster as left-to-right or not. Neutral characters
+	// between two left-to-right ones join their run.
+	ltr := make([]bool, len(clusters))
+	strong := make([]int, len(clusters)) // 1 LTR, -1 RTL, 0 neutral
+	for i, c := range clusters {
+		r := []rune(c)[0]
+		switch {
+		case isRTL(r):
+			strong[i] = -1
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			strong[i] = 1
+		}
+	}
+	for i := range clusters {
+		switch strong[i] {
+		case 1:
+			ltr[i] = true
+		case 0:
+			before, after := 0, 0
+			for j := i - 1; j >= 0 && before == 0; j-- {
+				before = strong[j]
+			}
+			for j := i + 1; j < len(clusters) && after == 0; j++ {
+				after = strong[j]
+			}
+			ltr[i] = before == 1 && after == 1
+		}
+	}
+
+	// Reverse the units, keeping each left-to-right run intact.
+	var units []string
+	for i := 0; i < len(clusters); {
+		if ltr[i] {
+			j := i
+			for j < len(clusters) && ltr[j] {
+				j++
+			}
+			units = append(units, strings.Join(clusters[i:j], ""))
+			i = j
+			continue
+		}
+		c := clusters[i]
+		if m, ok := mirrored[[]rune(c)[0]]; ok && len([]rune(c)) == 1 {
+			c = string(m)
+		}
+		units = append(units, c)
+		i++
+	}
+	slices.Reverse(units)
+	return strings.Join(units, "")
+}
@@ -0,0 +1,175 @@
+package merger
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatMP4  = "mp4"
+	FormatWebM = "webm"
+	FormatGIF  = "gif"
+)
+
+// formatSpec lists the encoders a container accepts. The first entry of
+// each list is the default.
+type formatSpec struct {
+	videoCodecs []string
+	audioCodecs []string
+}
+
+var formats = map[string]formatSpec{
+	FormatMP4: {
+		videoCodecs: []string{"libx264", "libx265", "libaom-av1"},
+		audioCodecs: []string{"aac", "libmp3lame", "libopus"},
+	},
+	FormatWebM: {
+		videoCodecs: []string{"libvpx-vp9", "libvpx", "libaom-av1"},
+		audioCodecs: []string{"libopus", "libvorbis"},
+	},
+	FormatGIF: {
+		videoCodecs: []string{"gif"},
+	},
+}
+
+// probedCodecNames maps encoders to the codec name ffprobe reports for
+// their output, used to decide whether sources can be stream-copied.
+var probedCodecNames = map[string]string{
+	"libx264":    "h264",
+	"libx265":    "hevc",
+	"libaom-av1": "av1",
+	"libvpx-vp9": "vp9",
+	"libvpx":     "vp8",
+	"gif":        "gif",
+}
+
+// outputFormat returns the configured container, falling back to the
+// output path's extension and then mp4.
+func (config Config) outputFormat() string {
+	if config.OutputFormat != "" {
+		return config.OutputFormat
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(config.Dest.Output)), ".")
+	if _, ok := formats[ext]; ok {
+		return ext
+	}
+	return FormatMP4
+}
+
+// intermediateFormat is the container cards and normalized clips are
+// encoded in. GIF output is always re-encoded from mp4 intermediates
+// because GIF can't carry the audio the concat step expects.
+func (config Config) intermediateFormat() string {
+	if format := config.outputFormat(); format != FormatGIF {
+		return format
+	}
+	return FormatMP4
+}
+
+// canStreamCopy reports whether input's container is compatible enough
+// with the output container to copy its streams without re-encoding.
+func (config Config) canStreamCopy(input string) bool {
+	switch strings.ToLower(filepath.Ext(sourcePath(input))) {
+	case ".mp4", ".m4v", ".mov":
+		return config.outputFormat() == FormatMP4
+	case ".webm":
+		return config.outputFormat() == FormatWebM
+	}
+	return false
+}
+
+func (config Config) videoCodec(format string) string {
+	if format == config.outputFormat() && config.VideoCodec != "" {
+		return config.VideoCodec
+	}
+	return formats[format].videoCodecs[0]
+}
+
+func (config Config) audioCodec(format string) string {
+	if len(formats[format].audioCodecs) == 0 {
+		return ""
+	}
+	if format == config.outputFormat() && config.AudioCodec != "" {
+		return config.AudioCodec
+	}
+	return formats[format].audioCodecs[0]
+}
+
+// encoderArgs returns the ffmpeg codec arguments for encoding into format.
+func (config Config) encoderArgs(format string) []string {
+	args := []string{"-c:v", config.videoCodec(format)}
+	if format != FormatGIF {
+		args = append(args, "-pix_fmt", "yuv420p")
+	}
+	if codec := config.audioCodec(format); codec != "" {
+		args = append(args, "-c:a", codec)
+	} else {
+		args = append(args, "-an")
+	}
+	return append(args, config.threadArgs()...)
+}
+
+// outputArgs returns ConcatArgs for a merge step encoding into format.
+// They're meant for the final output, so intermediate parts in another
+// format don't get them.
+func (config Config) outputArgs(format string) []string {
+	if format != config.outputFormat() {
+		return nil
+	}
+	return config.ConcatArgs
+}
+
+// threadArgs returns the -threads output option, if Threads is set.
+func (config Config) threadArgs() []string {
+	if config.Threads <= 0 {
+		return nil
+	}
+	return []string{"-threads", strconv.Itoa(config.Threads)}
+}
+
+func validateFormat(config Config) []error {
+	format := config.outputFormat()
+	spec, ok := formats[format]
+	if !ok {
+		return []error{fmt.Errorf("outputFormat must be %q, %q, or %q, got %q", FormatMP4, FormatWebM, FormatGIF, format)}
+	}
+
+	var errs []error
+	if config.VideoCodec != "" && !slices.Contains(spec.videoCodecs, config.VideoCodec) {
+		errs = append(errs, fmt.Errorf("videoCodec %q can't be used in %s; choose one of %s",
+			config.VideoCodec, format, strings.Join(spec.videoCodecs, ", ")))
+	}
+	if config.AudioCodec != "" && !slices.Contains(spec.audioCodecs, config.AudioCodec) {
+		if len(spec.audioCodecs) == 0 {
+			errs = append(errs, fmt.Errorf("%s output has no audio, so audioCodec must be empty", format))
+		} else {
+			errs = append(errs, fmt.Errorf("audioCodec %q can't be used in %s; choose one of %s",
+				config.AudioCodec, format, strings.Join(spec.audioCodecs, ", ")))
+		}
+	}
+	return errs
+}
+
+// reservedArgs are managed by the merge itself, so passthrough arguments
+// can't set them.
+var reservedArgs = []string{"-i", "-y", "-n", "-f", "-progress"}
+
+// validateArgs checks passthrough arguments for options that would change
+// the inputs or outputs the merge relies on.
+func validateArgs(key string, args []string) []error {
+	var errs []error
+	for _, arg := range args {
+		if slices.Contains(reservedArgs, arg) {
+			errs = append(errs, fmt.Errorf("%s can't contain %q; inputs and outputs are set by the merge", key, arg))
+			continue
+		}
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(arg)), ".")
+		if _, ok := formats[ext]; ok && !strings.HasPrefix(arg, "-") {
+			errs = append(errs, fmt.Errorf("%s can't contain %q; it looks like an output path", key, arg))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,42 @@
+package merger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckFrameCounts returns an ErrTooManyFrames error naming the cards of
+// the merge that would have more than MaxCardFrames frames, so callers can
+// ask before a run fills the disk. It ignores Force.
+func CheckFrameCounts(config Config) error {
+	config = config.WithDefaults()
+	sources, err := resolveSources(config)
+	if err != nil {
+		return err
+	}
+	if config.Frame, err = resolveFrame(config, paths(sources)); err != nil {
+		return err
+	}
+	return checkCardFrames(config, planMerge(config, sources, ""))
+}
+
+func checkCardFrames(config Config, plan mergePlan) error {
+	if config.MaxCardFrames <= 0 {
+		return nil
+	}
+	var over []string
+	for _, c := range plan.cards() {
+		if c.frames > config.MaxCardFrames {
+			over = append(over, fmt.Sprintf("%q (%d frames)", c.text, c.frames))
+		}
+	}
+	if len(over) == 0 {
+		return nil
+	}
+	n := len(over)
+	if n > 3 {
+		over = append(over[:3], fmt.Sprintf("and %d more", n-3))
+	}
+	return withKind(ErrTooManyFrames, fmt.Errorf("%d card(s) exceed maxCardFrames (%d) at %d fps, check frame.rate and the durations: %s",
+		n, config.MaxCardFrames, config.Frame.Rate, strings.Join(over, ", ")))
+}
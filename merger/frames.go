@@ -0,0 +1,515 @@
+package merger
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+// DefaultCaption is the caption template used when text.caption is unset.
+const DefaultCaption = "Next: {basename}"
+
+// transitionText returns the caption shown before videos[index], preferring
+// a configured title keyed by path or base name, then a sidecar caption
+// file, over the caption template.
+func transitionText(config Config, videos []string, index int) string {
+	video := videos[index]
+	base := filepath.Base(sourcePath(video))
+	for _, key := range []string{video, base, nfc(video), nfc(base)} {
+		if title, ok := config.Titles[key]; ok {
+			return title
+		}
+	}
+	// A key typed decomposed only matches once it's normalized too.
+	for key, title := range config.Titles {
+		if k := nfc(key); k == nfc(video) || k == nfc(base) {
+			return title
+		}
+	}
+	if config.UseSidecarCaptions {
+		if caption, ok := sidecarCaption(video); ok {
+			return caption
+		}
+	}
+
+	template := config.Text.Caption
+	if template == "" {
+		template = DefaultCaption
+	}
+	return expandCaption(config, template, videos, index)
+}
+
+// sidecarCaption reads the caption in the .txt file next to video, if
+// there is one and it isn't blank. Line breaks are kept; trailing ones
+// are dropped.
+func sidecarCaption(video string) (string, bool) {
+	path := strings.TrimSuffix(video, filepath.Ext(video)) + ".txt"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	caption := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), " \t\r\n")
+	return caption, strings.TrimSpace(caption) != ""
+}
+
+// expandCaption substitutes {index} (1-based), {total}, {filename} (the
+// path as listed), {basename}, {modtime} (YYYY-MM-DD), and {duration}
+// (from ffprobe) for videos[index].
+func expandCaption(config Config, template string, videos []string, index int) string {
+	video := videos[index]
+	replacements := []string{
+		"{index}", strconv.Itoa(index + 1),
+		"{total}", strconv.Itoa(len(videos)),
+		"{filename}", nfc(video),
+		"{basename}", nfc(filepath.Base(sourcePath(video))),
+	}
+	if strings.Contains(template, "{modtime}") {
+		modtime := ""
+		if info, err := os.Stat(video); err == nil {
+			modtime = info.ModTime().Format("2006-01-02")
+		}
+		replacements = append(replacements, "{modtime}", modtime)
+	}
+	if strings.Contains(template, "{duration}") {
+		duration := ""
+		if seconds, err := probeDuration(config, video); err == nil {
+			duration = (time.Duration(seconds) * time.Second).String()
+		}
+		replacements = append(replacements, "{duration}", duration)
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// fadeAlpha returns the caption opacity for frame j of numFrames.
+func fadeAlpha(text TextConfig, j, numFrames int) float64 {
+	alpha := 1.0
+	if text.FadeInFrames > 0 && j < text.FadeInFrames {
+		alpha = math.Min(alpha, float64(j+1)/float64(text.FadeInFrames))
+	}
+	if remaining := numFrames - j; text.FadeOutFrames > 0 && remaining <= text.FadeOutFrames {
+		alpha = math.Min(alpha, float64(remaining-1)/float64(text.FadeOutFrames))
+	}
+	return alpha
+}
+
+func withAlpha(c color.Color, alpha float64) color.Color {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(math.Round(float64(n.A) * alpha))
+	return n
+}
+
+// lineSpacing is the caption line height as a multiple of the font height.
+const lineSpacing = 1.5
+
+// wrapCaption splits text into lines no wider than maxWidth. Explicit
+// newlines are kept, and words wider than a line are broken mid-word.
+func wrapCaption(dc *gg.Context, text string, maxWidth float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		wrapped := dc.WordWrap(paragraph, maxWidth)
+		if len(wrapped) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		for _, line := range wrapped {
+			lines = append(lines, breakLongLine(dc, line, maxWidth)...)
+		}
+	}
+	return lines
+}
+
+func breakLongLine(dc *gg.Context, line string, maxWidth float64) []string {
+	if w, _ := dc.MeasureString(line); w <= maxWidth {
+		return []string{line}
+	}
+	var lines []string
+	var current []rune
+	for _, r := range line {
+		candidate := append(current, r)
+		if w, _ := dc.MeasureString(string(candidate)); w > maxWidth && len(current) > 0 {
+			lines = append(lines, string(current))
+			candidate = []rune{r}
+		}
+		current = candidate
+	}
+	return append(lines, string(current))
+}
+
+// minFitSize is the smallest font size fitFace shrinks to.
+const minFitSize = 8
+
+// fitFace returns a face at the configured font size, or smaller if text
+// wouldn't otherwise fit within the frame's margins.
+func fitFace(config Config, fonts fontChain, text string) font.Face {
+	width, height := float64(config.Frame.Width), float64(config.Frame.Height)
+	margin := float64(config.Text.Margin)
+	maxWidth := math.Min(width*config.Text.MaxWidthFraction, width-2*margin)
+	// Measuring doesn't draw, so a tiny context will do.
+	dc := gg.NewContext(1, 1)
+	for size := config.Font.Size; ; size *= 0.9 {
+		face := fonts.face(size)
+		dc.SetFontFace(face)
+		lines := wrapCaption(dc, text, maxWidth)
+		block := dc.FontHeight() * (lineSpacing*float64(len(lines)-1) + 1)
+		if block <= height-2*margin || size*0.9 < minFitSize {
+			return face
+		}
+	}
+}
+
+// captionLine is one wrapped caption line and where it's anchored.
+type captionLine struct {
+	text   string
+	x, y   float64
+	anchor float64
+}
+
+// layoutCaption wraps caption to the configured width fraction and places
+// it as a block aligned within the frame's margins.
+func layoutCaption(dc *gg.Context, text TextConfig, caption string) []captionLine {
+	width, height := float64(dc.Width()), float64(dc.Height())
+	margin := float64(text.Margin)
+	lines := wrapCaption(dc, caption, math.Min(width*text.MaxWidthFraction, width-2*margin))
+
+	var x, ax float64
+	switch text.HAlign {
+	case AlignLeft:
+		x, ax = margin, 0
+	case AlignRight:
+		x, ax = width-margin, 1
+	default:
+		x, ax = width/2, 0.5
+	}
+	if text.TextX != nil {
+		x = text.TextX.resolve(width)
+	}
+
+	fontHeight := dc.FontHeight()
+	lineHeight := fontHeight * lineSpacing
+	blockSpan := lineHeight * float64(len(lines)-1)
+	var y float64
+	switch text.VAlign {
+	case AlignTop:
+		y = margin + fontHeight/2
+	case AlignBottom:
+		y = height - margin - fontHeight/2 - blockSpan
+	default:
+		y = height/2 - blockSpan/2
+	}
+	if text.TextY != nil {
+		// y is the middle of the first line.
+		switch at := text.TextY.resolve(height); text.VAlign {
+		case AlignTop:
+			y = at + fontHeight/2
+		case AlignBottom:
+			y = at - fontHeight/2 - blockSpan
+		default:
+			y = at - blockSpan/2
+		}
+	}
+
+	placed := make([]captionLine, len(lines))
+	for i, line := range lines {
+		if text.RTL {
+			line = visualOrder(line)
+		}
+		placed[i] = captionLine{text: line, x: x, y: y, anchor: ax}
+		y += lineHeight
+	}
+	return placed
+}
+
+func drawLines(dc *gg.Context, lines []captionLine, dx, dy float64) {
+	for _, line := range lines {
+		dc.DrawStringAnchored(line.text, line.x+dx, line.y+dy, line.anchor, 0.5)
+	}
+}
+
+// animationProgress returns how far the caption's entrance animation has
+// got at frame j of numFrames, eased so it slows as it settles. The
+// animation takes the first half of the card; the caption then holds.
+func animationProgress(j, numFrames int) float64 {
+	t := math.Min(1, float64(j)/math.Max(1, float64(numFrames/2)))
+	return 1 - math.Pow(1-t, 3)
+}
+
+// animateCaption transforms dc so the caption drawn next is placed as the
+// configured animation has it at progress p.
+func animateCaption(dc *gg.Context, animation string, lines []captionLine, p float64) {
+	switch animation {
+	case AnimationSlideLeft:
+		dc.Translate(-(1-p)*float64(dc.Width()), 0)
+	case AnimationSlideUp:
+		dc.Translate(0, (1-p)*float64(dc.Height()))
+	case AnimationZoom:
+		if len(lines) == 0 {
+			return
+		}
+		// Scaling to zero would leave a degenerate matrix.
+		scale := math.Max(p, 0.01)
+		cy := (lines[0].y + lines[len(lines)-1].y) / 2
+		dc.ScaleAbout(scale, scale, lines[0].x, cy)
+	}
+}
+
+// drawCaption draws the caption with its shadow and outline, if enabled,
+// beneath the fill. alpha scales all three for fades, and progress drives
+// the entrance animation.
+func drawCaption(dc *gg.Context, face font.Face, config TextConfig, style frameStyle, caption string, alpha, progress float64) {
+	dc.SetFontFace(face)
+	lines := layoutCaption(dc, config, caption)
+	dc.Push()
+	defer dc.Pop()
+	animateCaption(dc, config.TextAnimation, lines, progress)
+
+	if style.shadowColor != nil {
+		drawShadow(dc, face, lines, config.Shadow, withAlpha(style.shadowColor, alpha))
+	}
+	// The outline is the caption stamped at every offset within the stroke
+	// width, so the fill drawn on top is ringed by the stroke color.
+	if w := config.Stroke.Width; style.strokeColor != nil && w > 0 {
+		dc.SetColor(withAlpha(style.strokeColor, alpha))
+		for dy := -w; dy <= w; dy++ {
+			for dx := -w; dx <= w; dx++ {
+				if dx*dx+dy*dy <= w*w && (dx != 0 || dy != 0) {
+					drawLines(dc, lines, float64(dx), float64(dy))
+				}
+			}
+		}
+	}
+
+	dc.SetColor(withAlpha(style.textColor, alpha))
+	drawLines(dc, lines, 0, 0)
+}
+
+// drawShadow draws the caption offset and blurred on its own layer, then
+// composites the layer onto dc.
+func drawShadow(dc *gg.Context, face font.Face, lines []captionLine, shadow ShadowConfig, c color.Color) {
+	layer := gg.NewContext(dc.Width(), dc.Height())
+	layer.SetFontFace(face)
+	layer.SetColor(c)
+	drawLines(layer, lines, float64(shadow.OffsetX), float64(shadow.OffsetY))
+	img := layer.Image().(*image.RGBA)
+	if shadow.Blur > 0 {
+		boxBlur(img, shadow.Blur)
+	}
+	dc.DrawImage(img, 0, 0)
+}
+
+// boxBlur blurs img in place with a box of the given radius, horizontally
+// then vertically, keeping a running sum so each pass is linear in the
+// image size.
+func boxBlur(img *image.RGBA, radius int) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	buf := make([]uint8, len(img.Pix))
+	blurPass(img.Pix, buf, w, h, 4, img.Stride, radius)
+	blurPass(buf, img.Pix, h, w, img.Stride, 4, radius)
+}
+
+// blurPass averages src into dst along lines of n pixels, where step is
+// the byte distance between neighbouring pixels in a line and stride the
+// distance between lines.
+func blurPass(src, dst []uint8, n, lines, step, stride, radius int) {
+	window := 2*radius + 1
+	for line := 0; line < lines; line++ {
+		base := line * stride
+		for ch := 0; ch < 4; ch++ {
+			sum := 0
+			for i := -radius; i <= radius; i++ {
+				sum += int(src[base+clampIndex(i, n)*step+ch])
+			}
+			for i := 0; i < n; i++ {
+				dst[base+i*step+ch] = uint8(sum / window)
+				sum += int(src[base+clampIndex(i+radius+1, n)*step+ch])
+				sum -= int(src[base+clampIndex(i-radius, n)*step+ch])
+			}
+		}
+	}
+}
+
+func clampIndex(i, n int) int {
+	return max(0, min(i, n-1))
+}
+
+// drawProgressBar draws a thin bar along the bottom of the frame, filled
+// to progress over a faint track in the same color.
+func drawProgressBar(dc *gg.Context, text TextConfig, c color.Color, progress float64) {
+	width, height := float64(dc.Width()), float64(dc.Height())
+	barHeight := math.Max(4, height/120)
+	margin := math.Max(float64(text.Margin), height/40)
+	x, y := margin, height-margin-barHeight
+	trackWidth := width - 2*margin
+
+	dc.SetColor(withAlpha(c, 0.25))
+	dc.DrawRectangle(x, y, trackWidth, barHeight)
+	dc.Fill()
+	dc.SetColor(c)
+	dc.DrawRectangle(x, y, trackWidth*progress, barHeight)
+	dc.Fill()
+}
+
+// renderFrame draws the frame for job onto dc, which is reused from frame
+// to frame to save reallocating the image, so it's cleared first.
+func renderFrame(dc *gg.Context, config Config, face font.Face, job frameJob, style frameStyle) {
+	dc.SetColor(color.Transparent)
+	dc.Clear()
+	drawBackground(dc, style)
+	if style.logo != nil {
+		drawLogo(dc, style.logo, config.Logo)
+	}
+	drawCaption(dc, face, config.Text, style, job.card.text, job.alpha, animationProgress(job.frame, job.card.frames))
+	if config.Text.ShowProgressBar {
+		drawProgressBar(dc, config.Text, withAlpha(style.textColor, job.alpha), job.card.progress)
+	}
+}
+
+// static reports whether every frame of a card drawn by config looks the
+// same apart from its fade, so it can be drawn once and reused.
+func static(config Config) bool {
+	return config.Text.TextAnimation == "" || config.Text.TextAnimation == AnimationNone
+}
+
+// linkFrame makes dest a copy of the frame at src, hard linking when the
+// filesystem allows.
+func linkFrame(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	return copyFile(src, dest)
+}
+
+// saveFrame writes a rendered frame in the configured frame format.
+func saveFrame(config Config, img image.Image, path string) error {
+	if config.FrameFormat == FrameFormatJPEG {
+		return gg.SaveJPG(path, img, config.FrameQuality)
+	}
+	return gg.SavePNG(path, img)
+}
+
+type frameJob struct {
+	card  card
+	frame int
+	alpha float64
+	// copies are later frames that look the same, linked to this one
+	// rather than drawn again.
+	copies []int
+}
+
+// generateCardFrames renders every frame of cards on a bounded worker
+// pool and returns how many images it drew; a frame that looks like the
+// one before it is linked to that one instead of drawn. The first error
+// stops the remaining jobs. With supersampling,
+// frames are rendered larger and shrunk to the frame size as they're
+// saved, so looks must come from newCardLooks.
+func generateCardFrames(config Config, cards []card, looks []cardLook) (int, error) {
+	render := config.supersampled()
+	workers := config.Frame.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// Preview backgrounds are decoded once per card, before any worker
+	// needs them.
+	backgrounds := map[string]image.Image{}
+	for _, c := range cards {
+		if c.background == "" {
+			continue
+		}
+		img, err := loadPreview(c.background, looks[c.style].render, config.PreviewBackground.Dim)
+		if err != nil {
+			config.logger().Infof("Warning: %v; using the card background", err)
+			continue
+		}
+		backgrounds[c.name] = img
+	}
+
+	jobs := make(chan frameJob)
+	done := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Font faces cache glyphs and aren't safe for concurrent use.
+			faces := map[int]font.Face{}
+			fitted := map[string]font.Face{}
+			dc := gg.NewContext(render.Frame.Width, render.Frame.Height)
+			for job := range jobs {
+				framePath := job.card.framePath(job.frame)
+				look := looks[job.card.style]
+				if faces[job.card.style] == nil {
+					faces[job.card.style] = look.fonts.face(look.render.Font.Size)
+				}
+				f := faces[job.card.style]
+				if job.card.fit {
+					if fitted[job.card.name] == nil {
+						fitted[job.card.name] = fitFace(look.render, look.fonts, job.card.text)
+					}
+					f = fitted[job.card.name]
+				}
+				style := look.style
+				if bg, ok := backgrounds[job.card.name]; ok {
+					style.bgImage = bg
+				}
+				renderFrame(dc, look.render, f, job, style)
+				img := scaleImage(dc.Image(), config.Frame.Width, config.Frame.Height)
+				if err := saveFrame(config, img, framePath); err != nil {
+					fail(err)
+					return
+				}
+				for _, frame := range job.copies {
+					if err := linkFrame(framePath, job.card.framePath(frame)); err != nil {
+						fail(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	drawn := 0
+feed:
+	for _, c := range cards {
+		reuse := static(looks[c.style].render)
+		for j := 0; j < c.images(); j++ {
+			job := frameJob{card: c, frame: j, alpha: fadeAlpha(config.Text, j, c.frames)}
+			for reuse && j+1 < c.images() && fadeAlpha(config.Text, j+1, c.frames) == job.alpha {
+				j++
+				job.copies = append(job.copies, j)
+			}
+			select {
+			case jobs <- job:
+				drawn++
+			case <-done:
+				break feed
+			case <-config.context().Done():
+				fail(config.context().Err())
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return drawn, firstErr
+}
@@ -0,0 +1,49 @@
+package merger
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkGenerateCardFrames renders a three second card at 30 fps with
+// half-second fades on one worker. A static card draws each fade level
+// once and links the frames in between, so it draws 30 of its 90 images;
+// an animated card, which can't reuse frames, draws all 90 and is the
+// baseline.
+func BenchmarkGenerateCardFrames(b *testing.B) {
+	for _, bb := range []struct {
+		name      string
+		animation string
+	}{
+		{"static", ""},
+		{"animated", AnimationSlideUp},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			config := DefaultConfig()
+			config.Logger = &Logger{Level: LogQuiet, Out: io.Discard}
+			config.Font.Path = filepath.Join("..", "font", "Cascadia.ttf")
+			config.Frame = FrameConfig{Width: 640, Height: 360, Rate: 30, Workers: 1}
+			config.Text.TextAnimation = bb.animation
+			config.Text.FadeInFrames = 15
+			config.Text.FadeOutFrames = 15
+			fonts, err := loadFonts(config.Font)
+			if err != nil {
+				b.Fatal(err)
+			}
+			looks, err := newCardLooks(config, fonts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			c := card{name: "001", dir: b.TempDir(), text: "Next: beach.mp4", frames: 90, frameExt: "png"}
+			b.ResetTimer()
+			drawn := 0
+			for i := 0; i < b.N; i++ {
+				if drawn, err = generateCardFrames(config, []card{c}, looks); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(drawn), "draws/op")
+		})
+	}
+}
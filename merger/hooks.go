@@ -0,0 +1,61 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	hookInput  = "{input}"
+	hookOutput = "{output}"
+)
+
+// runHook runs the command template on each input, writing its output to
+// runDir as name_N with the input's extension, and returns the outputs in
+// order. An empty template returns inputs unchanged.
+func runHook(config Config, hook string, template []string, runDir string, inputs []string) ([]string, error) {
+	if len(template) == 0 {
+		return inputs, nil
+	}
+	outputs := make([]string, len(inputs))
+	for i, input := range inputs {
+		output := filepath.Join(runDir, fmt.Sprintf("%s_%d%s", hook, i, filepath.Ext(sourcePath(input))))
+		args := make([]string, len(template))
+		for j, arg := range template {
+			args[j] = strings.NewReplacer(hookInput, input, hookOutput, output).Replace(arg)
+		}
+		config.logger().Infof("Running %s on %s", hook, input)
+		cmd := exec.CommandContext(config.context(), args[0], args[1:]...)
+		cmd.Stdout = config.logger().FFmpegOutput()
+		cmd.Stderr = config.logger().FFmpegOutput()
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("error running %s on '%s': %w", hook, input, err)
+		}
+		if _, err := os.Stat(output); err != nil {
+			return nil, fmt.Errorf("%s didn't write its output for '%s': %w", hook, input, err)
+		}
+		outputs[i] = output
+	}
+	return outputs, nil
+}
+
+// validateHook checks that a command template names a program and uses
+// both {input} and {output}.
+func validateHook(key string, template []string) []error {
+	if len(template) == 0 {
+		return nil
+	}
+	var errs []error
+	if template[0] == "" {
+		errs = append(errs, fmt.Errorf("%s must start with a program to run", key))
+	}
+	for _, token := range []string{hookInput, hookOutput} {
+		if !strings.Contains(strings.Join(template, "\x00"), token) {
+			errs = append(errs, fmt.Errorf("%s must reference %s", key, token))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+package merger
+
+import (
+	"bufio"
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// hardwareEncoder is a supported hardware H.264 encoder: the -hwaccel
+// used to decode sources on the same device and the pixel format it
+// takes.
+type hardwareEncoder struct {
+	hwaccel string
+	pixFmt  string
+}
+
+var hardwareEncoders = map[string]hardwareEncoder{
+	"h264_nvenc":        {hwaccel: "cuda", pixFmt: "yuv420p"},
+	"h264_videotoolbox": {hwaccel: "videotoolbox", pixFmt: "yuv420p"},
+	"h264_qsv":          {hwaccel: "qsv", pixFmt: "nv12"},
+}
+
+// hardwareEncoderNames returns the supported hardware encoders in a
+// stable order.
+func hardwareEncoderNames() []string {
+	names := make([]string, 0, len(hardwareEncoders))
+	for name := range hardwareEncoders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// resolveHardwareEncoder returns config.HardwareEncoder if this ffmpeg
+// build lists it in "ffmpeg -encoders". Otherwise it warns and returns "",
+// so the intermediates fall back to libx264. A listed encoder can still
+// fail if the machine lacks the hardware; that surfaces as an encode
+// error.
+func resolveHardwareEncoder(config Config) string {
+	name := config.HardwareEncoder
+	if name == "" {
+		return ""
+	}
+	var stdout bytes.Buffer
+	cmd := config.ffmpegCommand("-hide_banner", "-encoders")
+	cmd.Stdout = &stdout
+	if err := config.run(cmd); err != nil {
+		config.logger().Infof("Warning: could not list ffmpeg's encoders (%v); using libx264 instead of %s", err, name)
+		return ""
+	}
+	// Encoder lines look like " V....D h264_nvenc   NVIDIA NVENC H.264 encoder".
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[1] == name {
+			config.logger().Infof("Using hardware encoder: %s", name)
+			return name
+		}
+	}
+	config.logger().Infof("Warning: ffmpeg has no %s encoder; using libx264 instead", name)
+	return ""
+}
+
+// intermediateEncoderArgs returns the codec arguments for cards and for
+// trimmed and normalized clips: encoderArgs for the intermediate format,
+// with the hardware encoder swapped in when one is in use.
+func (config Config) intermediateEncoderArgs() []string {
+	args := config.encoderArgs(config.intermediateFormat())
+	hw, ok := hardwareEncoders[config.HardwareEncoder]
+	if !ok || config.intermediateFormat() != FormatMP4 {
+		return args
+	}
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-c:v":
+			args[i+1] = config.HardwareEncoder
+		case "-pix_fmt":
+			args[i+1] = hw.pixFmt
+		}
+	}
+	return args
+}
+
+// hwaccelArgs returns the input options that decode sources on the
+// hardware encoder's device, if one is in use.
+func (config Config) hwaccelArgs() []string {
+	hw, ok := hardwareEncoders[config.HardwareEncoder]
+	if !ok {
+		return nil
+	}
+	return []string{"-hwaccel", hw.hwaccel}
+}
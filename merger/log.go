@@ -0,0 +1,99 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	// LogQuiet prints nothing; only the returned error reports failures.
+	LogQuiet LogLevel = iota
+	// LogNormal prints high-level steps.
+	LogNormal
+	// LogVerbose also streams ffmpeg's own output.
+	LogVerbose
+)
+
+// Logger writes merge messages to Out, filtered by Level.
+type Logger struct {
+	Level LogLevel
+	Out   io.Writer
+	// Record, when set, also receives every message, how long each step
+	// took, and the outcome of the merge, whatever the Level.
+	Record *slog.Logger
+}
+
+var defaultLogger = &Logger{Level: LogNormal, Out: os.Stderr}
+
+func (l *Logger) Infof(format string, args ...any) {
+	if l.Level >= LogNormal {
+		fmt.Fprintf(l.Out, format+"\n", args...)
+	}
+	if l.Record != nil {
+		msg := fmt.Sprintf(format, args...)
+		level := slog.LevelInfo
+		if strings.HasPrefix(msg, "Warning:") {
+			level = slog.LevelWarn
+		}
+		l.Record.Log(context.Background(), level, msg)
+	}
+}
+
+// timing records how long step took since start, with extra attributes
+// as key-value pairs.
+func (l *Logger) timing(step string, start time.Time, attrs ...any) {
+	if l.Record != nil {
+		l.Record.Info("step finished", append([]any{"step", step, "duration", time.Since(start)}, attrs...)...)
+	}
+}
+
+// result records the outcome of a merge.
+func (l *Logger) result(result Result, err error) {
+	switch {
+	case l.Record == nil:
+	case err != nil:
+		l.Record.Error("merge failed", "error", err)
+	default:
+		l.Record.Info("merge finished", "output", result.Output, "clips", result.Clips,
+			"transitions", result.Transitions, "frames", result.Frames,
+			"elapsed", result.Elapsed, "outputBytes", result.OutputBytes)
+	}
+}
+
+// openLogFile returns config with its logger also recording to LogFile,
+// appending to it, and a func that closes the file.
+func openLogFile(config Config) (Config, func(), error) {
+	if config.LogFile == "" {
+		return config, func() {}, nil
+	}
+	f, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return config, nil, fmt.Errorf("error opening log file '%s': %w", config.LogFile, err)
+	}
+	logger := *config.logger()
+	logger.Record = slog.New(slog.NewTextHandler(f, nil))
+	config.Logger = &logger
+	return config, func() { f.Close() }, nil
+}
+
+// FFmpegOutput is where ffmpeg's stdout and stderr are sent.
+func (l *Logger) FFmpegOutput() io.Writer {
+	if l.Level >= LogVerbose {
+		return l.Out
+	}
+	return io.Discard
+}
+
+func (config Config) logger() *Logger {
+	if config.Logger != nil {
+		return config.Logger
+	}
+	return defaultLogger
+}
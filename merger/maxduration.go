@@ -0,0 +1,61 @@
+package merger
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// capDuration writes input to output cut to config.MaxDuration seconds,
+// stream copying. An input already within the cap is moved into place
+// instead. It warns with how much was cut.
+func capDuration(config Config, input, output string) error {
+	duration, err := probeDuration(config, input)
+	if err == nil && duration <= config.MaxDuration {
+		return moveFile(input, output)
+	}
+	if err == nil {
+		config.logger().Infof("Warning: output is %.1fs, over maxDuration %gs; cutting the last %.1fs",
+			duration, config.MaxDuration, duration-config.MaxDuration)
+	} else {
+		config.logger().Infof("Warning: could not probe the output length (%v); cutting it at maxDuration %gs", err, config.MaxDuration)
+	}
+
+	args := []string{"-y", "-progress", "pipe:1", "-i", input, "-map", "0",
+		"-t", strconv.FormatFloat(config.MaxDuration, 'f', -1, 64), "-c", "copy", output}
+	return runWithProgress(config, args, config.MaxDuration)
+}
+
+// capChapters drops the chapters that start after the cut and ends the
+// rest by it.
+func capChapters(chapters []chapter, max float64) []chapter {
+	var kept []chapter
+	for _, c := range chapters {
+		if c.start >= max {
+			break
+		}
+		c.end = math.Min(c.end, max)
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+// capCues drops the cues that start after the cut and ends the rest by it.
+func capCues(cues []cue, max float64) []cue {
+	var kept []cue
+	for _, c := range cues {
+		if c.start >= max {
+			break
+		}
+		c.end = math.Min(c.end, max)
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+func validateMaxDuration(config Config) []error {
+	if config.MaxDuration < 0 {
+		return []error{fmt.Errorf("maxDuration must be a positive number of seconds, or 0 for no cap, got %g", config.MaxDuration)}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCapDurationAcrossFilesystems(t *testing.T) {
+	rename = func(src, dest string) error {
+		return &os.LinkError{Op: "rename", Old: src, New: dest, Err: syscall.EXDEV}
+	}
+	defer func() { rename = os.Rename }()
+
+	runner := &fakeRunner{}
+	config := testConfig(t, runner, 0)
+	// The fake output lasts 5s, so it's already within the cap.
+	config.MaxDuration = 10
+	input := filepath.Join(t.TempDir(), "merged.mp4")
+	if err := os.WriteFile(input, []byte("merged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := capDuration(config, input, config.Dest.Output); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(config.Dest.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "merged" {
+		t.Errorf("output = %q, want the merged file", got)
+	}
+	if _, err := os.Stat(input); !os.IsNotExist(err) {
+		t.Errorf("merged file left in the run dir: %v", err)
+	}
+	if calls := runner.ffmpegCalls(); len(calls) > 0 {
+		t.Errorf("output within the cap was re-muxed: %q", calls)
+	}
+}
@@ -0,0 +1,666 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveOutput returns the configured output path, or a timestamped
+// default under ./dest when none is set. A path containing "{" is treated
+// as a template: {date} (YYYY-MM-DD), {time} (HH-MM-SS), {count} (number
+// of source clips), and {unix} (seconds since the epoch) are substituted.
+func resolveOutput(config Config, count int) string {
+	now := time.Now()
+	output := config.Dest.Output
+	if output == "" {
+		return fmt.Sprintf("./dest/output_%02d_%02d_%d_%02d_%02d_%02d.%s",
+			now.Day(), now.Month(), now.Year(),
+			now.Hour(), now.Minute(), now.Second(), config.outputFormat())
+	}
+	if !strings.Contains(output, "{") {
+		return output
+	}
+	return strings.NewReplacer(
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("15-04-05"),
+		"{count}", strconv.Itoa(count),
+		"{unix}", strconv.FormatInt(now.Unix(), 10),
+	).Replace(output)
+}
+
+// concatEntry formats path as a concat demuxer "file" line. The demuxer
+// resolves relative paths against the list file's directory, so paths are
+// made absolute; URLs are kept as they are. Single quotes can't be escaped
+// inside a quoted string, so each one closes the quote, adds an escaped
+// quote, and reopens it.
+func concatEntry(path string) (string, error) {
+	abs := path
+	if !IsRemote(path) {
+		var err error
+		if abs, err = filepath.Abs(path); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`)), nil
+}
+
+// buildFileList returns the concat demuxer list that joins segments in
+// order. It only formats paths, so it touches neither the disk nor ffmpeg.
+func buildFileList(segments []string) (string, error) {
+	var list strings.Builder
+	for _, segment := range segments {
+		entry, err := concatEntry(segment)
+		if err != nil {
+			return "", err
+		}
+		list.WriteString(entry)
+	}
+	return list.String(), nil
+}
+
+// encodeCard turns a card's frames into a video with a silent audio track.
+func encodeCard(config Config, c card, silence string) error {
+	args := []string{"-y", "-framerate", fmt.Sprintf("%d", config.Frame.Rate)}
+	if c.still {
+		seconds := float64(c.frames) / float64(config.Frame.Rate)
+		args = append(args, "-loop", "1", "-t", strconv.FormatFloat(seconds, 'f', -1, 64), "-i", c.framePath(0))
+	} else {
+		args = append(args, "-i", c.framePattern())
+	}
+	args = append(args, "-f", "lavfi", "-i", silence)
+	args = append(args, config.intermediateEncoderArgs()...)
+	if rate := config.outputRate(); rate != config.Frame.Rate {
+		args = append(args, "-r", strconv.Itoa(rate))
+	}
+	args = append(args, config.FFmpegArgs...)
+	cmd := config.ffmpegCommand(append(args, "-shortest", c.video)...)
+	cmd.Stdout = config.logger().FFmpegOutput()
+	cmd.Stderr = config.logger().FFmpegOutput()
+	return config.run(cmd)
+}
+
+// encodeCards encodes cards on a bounded pool of concurrent ffmpeg runs.
+// The first failure cancels the encodes still running and is returned.
+func encodeCards(config Config, cards []card, silence string) error {
+	workers := config.EncodeWorkers
+	if workers <= 0 {
+		// ffmpeg is multi-threaded itself, so use half the cores.
+		workers = max(1, runtime.NumCPU()/2)
+	}
+
+	ctx, cancel := context.WithCancel(config.context())
+	defer cancel()
+	config.ctx = ctx
+
+	var mu sync.Mutex
+	var firstErr error
+	started := 0
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, c := range cards {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		mu.Lock()
+		started++
+		config.report(Progress{Stage: StageTransition, Current: started, Total: len(cards)})
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			err := config.retry("Transition encode", func() error {
+				return encodeCard(config, c, silence)
+			})
+			if err == nil {
+				config.logger().timing("transition", start, "card", c.name, "text", c.text)
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Result summarizes a finished merge.
+type Result struct {
+	Output string
+	// Poster is the poster image's path, or empty if none was written.
+	Poster      string
+	Clips       int
+	Transitions int
+	// Frames counts the card frame images drawn, including the intro and
+	// outro. A still card is drawn once, as is a run of identical frames,
+	// and cards reused from the cache aren't drawn at all.
+	Frames      int
+	Elapsed     time.Duration
+	OutputBytes int64
+}
+
+// Merge renders the transition cards, encodes them, and concatenates them
+// with the source videos. It returns the path of the merged video.
+func Merge(config Config) (string, error) {
+	result, err := MergeWithResult(config)
+	return result.Output, err
+}
+
+// MergeWithResult is Merge, returning a summary of the work done.
+func MergeWithResult(config Config) (Result, error) {
+	return MergeContext(context.Background(), config)
+}
+
+// MergeContext is MergeWithResult, stopping when ctx is canceled. The
+// running ffmpeg is killed and intermediate files are still removed.
+func MergeContext(ctx context.Context, config Config) (Result, error) {
+	config.ctx = ctx
+	config, closeLog, err := openLogFile(config)
+	if err != nil {
+		return Result{}, err
+	}
+	defer closeLog()
+	result, err := merge(config)
+	if err != nil && ctx.Err() != nil {
+		result, err = Result{}, fmt.Errorf("merge canceled: %w", ctx.Err())
+	}
+	config.logger().result(result, err)
+	config.Metrics.merged(result, err)
+	return result, err
+}
+
+func merge(config Config) (Result, error) {
+	start := time.Now()
+	config = config.WithDefaults()
+	config.probes = newProbeCache()
+	// Copies of the config share the cache, so the run hashes each source
+	// once.
+	config.hashes = newHashCache()
+	if errs := ValidateConfig(config); len(errs) > 0 {
+		return Result{}, withKind(ErrConfigInvalid, fmt.Errorf("invalid config: %w", errors.Join(errs...)))
+	}
+
+	// --- Load Videos ---
+	sources, err := resolveSources(config)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := CheckTools(config); err != nil {
+		return Result{}, err
+	}
+	if err := checkSourcesExist(config, sources); err != nil {
+		return Result{}, err
+	}
+
+	// --- Download Remote Sources ---
+	if config.usesS3() {
+		dir, err := os.MkdirTemp("", "video_merger_download_*")
+		if err != nil {
+			return Result{}, fmt.Errorf("error creating download directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		downloads, err := downloadS3Sources(config, dir, sources)
+		if err != nil {
+			return Result{}, err
+		}
+		sources = localize(sources, downloads)
+		config.Source = localize(config.Source, downloads)
+	}
+
+	videos := paths(sources)
+
+	output := resolveOutput(config, len(videos))
+	config.HardwareEncoder = resolveHardwareEncoder(config)
+
+	var tagSource string
+	if config.PreserveMetadata {
+		if tagSource, err = metadataSource(config, videos); err != nil {
+			return Result{}, withKind(ErrConfigInvalid, err)
+		}
+	}
+
+	if config.Frame, err = resolveFrame(config, videos); err != nil {
+		return Result{}, err
+	}
+	if config.Frame.Auto != "" {
+		config.logger().Infof("Matched frame to sources: %dx%d at %d fps",
+			config.Frame.Width, config.Frame.Height, config.Frame.Rate)
+	}
+	if config.ConcatMode == ConcatFast {
+		if config, err = resolveFastPath(config, videos); err != nil {
+			return Result{}, err
+		}
+	}
+
+	// --- Prepare Output Directory ---
+	var cleanup cleanupList
+	var runDir string
+	defer func() {
+		if config.KeepIntermediates {
+			if runDir != "" {
+				config.logger().Infof("Keeping intermediate files in: %s", runDir)
+			}
+			return
+		}
+		cleanup.run()
+	}()
+
+	if err := os.MkdirAll(config.Dest.IntermediateTextDir, 0755); err != nil {
+		return Result{}, fmt.Errorf("error creating intermediate text directory: %w", err)
+	}
+	// The shared intermediate dir is only removed once no other run is
+	// using it; os.Remove fails on a non-empty directory.
+	cleanup.addIfEmpty(config.Dest.IntermediateTextDir)
+
+	// Each run works in its own subdirectory so concurrent runs sharing
+	// an intermediate dir don't overwrite each other's frames and clips.
+	runDir, err = os.MkdirTemp(config.Dest.IntermediateTextDir, "run_*")
+	if err != nil {
+		return Result{}, fmt.Errorf("error creating run directory: %w", err)
+	}
+	cleanup.add(runDir)
+
+	// --- Load Font ---
+	fonts, err := loadFonts(config.Font)
+	if err != nil {
+		return Result{}, err
+	}
+
+	looks, err := newCardLooks(config, fonts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// --- Generate Transition Frames ---
+	plan := planMerge(config, sources, runDir)
+	if !config.Force {
+		if err := checkCardFrames(config, plan); err != nil {
+			return Result{}, err
+		}
+	}
+	config.logEstimate(plan, sources)
+	warnMissingGlyphs(config, fonts, plan.cards())
+
+	audio := resolveAudio(config, videos)
+	silence := fmt.Sprintf("anullsrc=r=%d:cl=%s", audio.SampleRate, audio.ChannelLayout)
+
+	var cache *cardCache
+	hashes := map[string]string{}
+	if config.CacheTransitions {
+		cache, err = loadCardCache(config.Dest.IntermediateTextDir)
+		if err != nil {
+			return Result{}, err
+		}
+		plan.update(func(c *card) {
+			hash := cardHash(config, *c, silence)
+			if path, ok := cache.lookup(hash); ok {
+				config.logger().Infof("Reusing cached transition: %q", c.text)
+				c.video = path
+				c.cached = true
+				return
+			}
+			hashes[c.video] = hash
+		})
+	}
+
+	// --- Pre-process Inputs ---
+	inputs, err := runHook(config, "preProcess", config.PreProcess, runDir, videos)
+	if err != nil {
+		return Result{}, err
+	}
+	inputs = append([]string(nil), inputs...)
+
+	// --- Trim Inputs ---
+	for i, source := range sources {
+		if !source.trimmed() {
+			continue
+		}
+		source.Path = inputs[i]
+		trimmed := filepath.Join(runDir, fmt.Sprintf("trimmed_%d.%s", i, config.intermediateFormat()))
+		if err := trimVideo(config, source, trimmed); err != nil {
+			return Result{}, fmt.Errorf("error trimming '%s': %w", videos[i], err)
+		}
+		inputs[i] = trimmed
+	}
+
+	// --- Normalize Inputs ---
+	normalize, err := normalizes(config, inputs)
+	if err != nil {
+		return Result{}, fmt.Errorf("error checking source formats: %w", err)
+	}
+
+	// The check follows trimming so it sees the inputs that are actually
+	// normalized, and counts the work still to come.
+	if !config.SkipDiskCheck {
+		if err := checkDiskSpace(config, runDir, plan, inputs, normalize); err != nil {
+			return Result{}, err
+		}
+	}
+
+	framesStart := time.Now()
+	pending := plan.pending()
+	if config.PreviewBackground.Enabled {
+		extractPreviews(config, pending)
+	}
+	drawn, err := generateCardFrames(config, pending, looks)
+	config.Metrics.framesDrawn(drawn)
+	if err != nil {
+		return Result{}, fmt.Errorf("error saving frame: %w", err)
+	}
+	config.logger().timing("frames", framesStart, "cards", len(pending))
+
+	if normalize {
+		config.logger().Infof("Source formats differ, re-encoding inputs to a common format")
+		for i, video := range inputs {
+			normalized := filepath.Join(runDir, fmt.Sprintf("normalized_%d.%s", i, config.intermediateFormat()))
+			if err := normalizeVideo(config, video, normalized); err != nil {
+				return Result{}, fmt.Errorf("error normalizing '%s': %w", videos[i], err)
+			}
+			inputs[i] = normalized
+		}
+	}
+
+	// --- Post-process Inputs ---
+	if inputs, err = runHook(config, "postProcess", config.PostProcess, runDir, inputs); err != nil {
+		return Result{}, err
+	}
+
+	// --- Create Transition Videos ---
+	cards := plan.pending()
+	if err := encodeCards(config, cards, silence); err != nil {
+		return Result{}, fmt.Errorf("error creating text transition video: %w", err)
+	}
+	for _, c := range cards {
+		if cache != nil {
+			if err := cache.store(hashes[c.video], c.video); err != nil {
+				return Result{}, fmt.Errorf("error caching transition video: %w", err)
+			}
+		}
+	}
+	if cache != nil {
+		if err := cache.save(); err != nil {
+			return Result{}, fmt.Errorf("error saving transition cache: %w", err)
+		}
+	}
+
+	// --- Encode Bumper ---
+	if plan.bumpers() > 0 {
+		if err := encodeBumper(config, plan.bumper, audio); err != nil {
+			return Result{}, fmt.Errorf("error encoding bumper '%s': %w", config.Bumper.Path, err)
+		}
+	}
+
+	// --- Merge Videos ---
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return Result{}, fmt.Errorf("error creating output directory: %w", err)
+	}
+	segments := plan.segments(inputs)
+	config.logger().Infof("Merging videos into: %s", output)
+	// A pipe can't be seeked in, so the merge finishes in a file that is
+	// then streamed out.
+	var stream string
+	if isStream(output) {
+		stream = output
+		output = filepath.Join(runDir, "stream."+config.outputFormat())
+	}
+	// Music, chapters, subtitles, and metadata are added in passes of
+	// their own, so merge to an intermediate.
+	tracks := config.Chapters || config.Subtitles.Enabled || tagSource != "" || config.Metadata.set()
+	merged := output
+	if config.Music.Path != "" || tracks || config.MaxDuration > 0 {
+		merged = filepath.Join(runDir, "merged."+config.outputFormat())
+	}
+	concatStart := time.Now()
+	if len(segments) == 1 {
+		// A lone video with no cards has nothing to join.
+		err = copyVideo(config, segments[0], merged)
+	} else if config.TransitionType == TransitionXfade {
+		err = xfadeVideos(config, runDir, segments, merged)
+	} else if config.ConcatMethod == ConcatMethodFilter {
+		err = concatFilter(config, runDir, segments, merged)
+	} else {
+		err = concatSegments(config, filepath.Join(runDir, "filelist.txt"), segments, merged)
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("error merging videos: %w", err)
+	}
+	config.logger().timing("concat", concatStart, "segments", len(segments))
+
+	// --- Cap Duration ---
+	if config.MaxDuration > 0 {
+		target := output
+		if config.Music.Path != "" || tracks {
+			target = filepath.Join(runDir, "capped."+config.outputFormat())
+		}
+		if err := capDuration(config, merged, target); err != nil {
+			return Result{}, fmt.Errorf("error cutting output to maxDuration: %w", err)
+		}
+		merged = target
+	}
+
+	// --- Add Music ---
+	if config.Music.Path != "" {
+		config.logger().Infof("Adding music: %s", config.Music.Path)
+		musicStart := time.Now()
+		target := output
+		if tracks {
+			target = filepath.Join(runDir, "music."+config.outputFormat())
+		}
+		if err := addMusic(config, merged, target); err != nil {
+			return Result{}, fmt.Errorf("error adding music: %w", err)
+		}
+		config.logger().timing("music", musicStart)
+		merged = target
+	}
+
+	// --- Add Chapters, Subtitles, and Metadata ---
+	if tracks {
+		tracksStart := time.Now()
+		var metadata, subtitles string
+		if config.Chapters {
+			chapters, err := planChapters(config, plan, inputs)
+			if err != nil {
+				return Result{}, fmt.Errorf("error timing chapters: %w", err)
+			}
+			metadata = filepath.Join(runDir, "chapters.txt")
+			if err := writeChapters(metadata, chapters); err != nil {
+				return Result{}, fmt.Errorf("error writing chapters: %w", err)
+			}
+		}
+		if config.Subtitles.Enabled {
+			cues, err := planSubtitles(config, plan, inputs)
+			if err != nil {
+				return Result{}, fmt.Errorf("error timing subtitles: %w", err)
+			}
+			subtitles = filepath.Join(runDir, "subtitles.srt")
+			if err := writeSubtitles(subtitles, cues); err != nil {
+				return Result{}, fmt.Errorf("error writing subtitles: %w", err)
+			}
+		}
+		if err := addTracks(config, merged, metadata, subtitles, tagSource, output); err != nil {
+			return Result{}, fmt.Errorf("error adding chapters, subtitles, and metadata: %w", err)
+		}
+		config.logger().timing("tracks", tracksStart)
+	}
+
+	// --- Stream Output ---
+	if stream != "" {
+		if err := streamOutput(config, output, stream); err != nil {
+			return Result{}, fmt.Errorf("error streaming to '%s': %w", stream, err)
+		}
+	}
+
+	// --- Write Poster ---
+	// The merge itself succeeded, so a poster failure is only reported.
+	var poster string
+	if config.Poster.Enabled && stream != "" {
+		config.logger().Infof("Skipping poster: output is a stream")
+	} else if config.Poster.Enabled {
+		if poster, err = writePoster(config, output); err != nil {
+			config.logger().Infof("Could not write poster: %v", err)
+		}
+	}
+
+	result := Result{
+		Output:      output,
+		Poster:      poster,
+		Clips:       len(videos),
+		Transitions: len(plan.transitions),
+		Frames:      drawn,
+		Elapsed:     time.Since(start),
+	}
+	if info, err := os.Stat(output); err == nil {
+		result.OutputBytes = info.Size()
+	}
+	if stream != "" {
+		result.Output = stream
+	}
+	return result, nil
+}
+
+// segmentsDuration sums the durations of segments in seconds, skipping
+// any that can't be probed.
+func segmentsDuration(config Config, segments []string) float64 {
+	var total float64
+	for _, segment := range segments {
+		if d, err := probeDuration(config, segment); err == nil {
+			total += d
+		}
+	}
+	return total
+}
+
+// concatSegments writes segments to a concat demuxer list at listPath and
+// merges them into output.
+func concatSegments(config Config, listPath string, segments []string, output string) error {
+	list, err := buildFileList(segments)
+	if err != nil {
+		return fmt.Errorf("error building filelist: %w", err)
+	}
+	if err := os.WriteFile(listPath, []byte(list), 0644); err != nil {
+		return fmt.Errorf("error writing filelist: %w", err)
+	}
+
+	return concatVideos(config, listPath, output, segmentsDuration(config, segments))
+}
+
+// copyVideo writes input to output, stream copying unless the concat mode
+// or the output container calls for a re-encode. Auto mode re-encodes if
+// the copy fails.
+func copyVideo(config Config, input, output string) error {
+	duration, _ := probeDuration(config, input)
+	reencode := config.ConcatMode == ConcatReencode || !config.canStreamCopy(input)
+	err := runCopy(config, input, output, duration, reencode)
+	if err != nil && !reencode && config.ConcatMode == ConcatAuto {
+		config.logger().Infof("Concat mode auto: stream copy failed (%v), re-encoding", err)
+		return runCopy(config, input, output, duration, true)
+	}
+	return err
+}
+
+func runCopy(config Config, input, output string, totalSeconds float64, reencode bool) error {
+	args := []string{"-y", "-progress", "pipe:1", "-i", input}
+	if reencode {
+		args = append(args, config.encoderArgs(config.outputFormat())...)
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	return runWithProgress(config, append(args, output), totalSeconds)
+}
+
+// durationTolerance is how far, in seconds, a stream-copied output may
+// drift from the summed input durations before auto mode re-encodes.
+const durationTolerance = 1.0
+
+// concatVideos merges the entries of listPath into output using the
+// configured concat mode.
+func concatVideos(config Config, listPath, output string, totalSeconds float64) error {
+	// Stream copy can't change containers away from the intermediates.
+	if config.outputFormat() != config.intermediateFormat() {
+		return runConcat(config, listPath, output, totalSeconds, true)
+	}
+
+	switch config.ConcatMode {
+	case ConcatReencode:
+		return runConcat(config, listPath, output, totalSeconds, true)
+	case ConcatAuto:
+		err := runConcat(config, listPath, output, totalSeconds, false)
+		if err == nil {
+			duration, probeErr := probeDuration(config, output)
+			if probeErr == nil && math.Abs(duration-totalSeconds) <= durationTolerance {
+				config.logger().Infof("Concat mode auto: stream copy output verified (%.1fs)", duration)
+				return nil
+			}
+			if probeErr != nil {
+				config.logger().Infof("Concat mode auto: could not verify stream copy output (%v), re-encoding", probeErr)
+			} else {
+				config.logger().Infof("Concat mode auto: stream copy output is %.1fs, expected %.1fs, re-encoding", duration, totalSeconds)
+			}
+		} else {
+			config.logger().Infof("Concat mode auto: stream copy failed (%v), re-encoding", err)
+		}
+		return runConcat(config, listPath, output, totalSeconds, true)
+	default:
+		return runConcat(config, listPath, output, totalSeconds, false)
+	}
+}
+
+func runConcat(config Config, listPath, output string, totalSeconds float64, reencode bool) error {
+	args := append([]string{"-y", "-progress", "pipe:1"}, concatInputArgs(listPath)...)
+	if reencode {
+		args = append(args, config.encoderArgs(config.outputFormat())...)
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, config.ConcatArgs...)
+	args = append(args, output)
+
+	return config.retry("Concat", func() error {
+		return runWithProgress(config, args, totalSeconds)
+	})
+}
+
+// runWithProgress runs ffmpeg with args, which must include
+// "-progress pipe:1", reporting progress against totalSeconds.
+func runWithProgress(config Config, args []string, totalSeconds float64) error {
+	cmd := config.ffmpegCommand(args...)
+	cmd.Stderr = config.logger().FFmpegOutput()
+	progress, w := io.Pipe()
+	cmd.Stdout = w
+
+	done := make(chan error, 1)
+	go func() {
+		err := config.run(cmd)
+		w.Close()
+		done <- err
+	}()
+	readFFmpegProgress(progress, totalSeconds, config.report)
+	// Drain anything left so the command never blocks writing progress.
+	io.Copy(io.Discard, progress)
+	return <-done
+}
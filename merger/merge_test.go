@@ -0,0 +1,226 @@
+package merger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeRunsFFmpegThroughRunner(t *testing.T) {
+	// Nothing on PATH: every command has to go through the Runner.
+	t.Setenv("PATH", t.TempDir())
+	runner := &fakeRunner{}
+	config := testConfig(t, runner, 3)
+	if _, err := Merge(config); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"-y", "-framerate", "10", "-loop", "1", "-t", "1", "-i", "text_001_frame_00000.png",
+			"-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo",
+			"-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac", "-shortest", "text_transition_001.mp4"},
+		{"-y", "-framerate", "10", "-loop", "1", "-t", "1", "-i", "text_002_frame_00000.png",
+			"-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo",
+			"-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac", "-shortest", "text_transition_002.mp4"},
+		{"-y", "-progress", "pipe:1", "-f", "concat", "-safe", "0",
+			"-protocol_whitelist", "file,http,https,tcp,tls,crypto", "-i", "filelist.txt",
+			"-c", "copy", "out.mp4"},
+	}
+	var got [][]string
+	for _, call := range runner.ffmpegCalls() {
+		got = append(got, baseNames(call))
+	}
+	// Cards are encoded concurrently, so they may finish in either order.
+	slices.SortStableFunc(got[:2], func(a, b []string) int { return strings.Compare(a[len(a)-1], b[len(b)-1]) })
+	if !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Errorf("ffmpeg calls:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestMergeReencodeConcatArgs(t *testing.T) {
+	runner := &fakeRunner{}
+	config := testConfig(t, runner, 2)
+	config.ConcatMode = ConcatReencode
+	config.ConcatArgs = []string{"-movflags", "+faststart"}
+	if _, err := Merge(config); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := runner.ffmpegCalls()
+	got := baseNames(calls[len(calls)-1])
+	want := []string{"-y", "-progress", "pipe:1", "-f", "concat", "-safe", "0",
+		"-protocol_whitelist", "file,http,https,tcp,tls,crypto", "-i", "filelist.txt",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac", "-movflags", "+faststart", "out.mp4"}
+	if !slices.Equal(got, want) {
+		t.Errorf("concat args:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestDryRunWithRunner(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	runner := &fakeRunner{}
+	config := testConfig(t, runner, 2)
+	var out bytes.Buffer
+	if err := DryRun(config, &out); err != nil {
+		t.Fatal(err)
+	}
+	// The estimate needs ffprobe, which only the Runner provides.
+	if !strings.Contains(out.String(), "Estimated output: 11s across 2 clips + 1 transitions") {
+		t.Errorf("dry run output has no estimate:\n%s", out.String())
+	}
+	if calls := runner.ffmpegCalls(); len(calls) > 0 {
+		t.Errorf("dry run ran ffmpeg: %q", calls)
+	}
+}
+
+func TestConcatEntry(t *testing.T) {
+	got, err := concatEntry("/tmp/it's a clip.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "file '/tmp/it'\\''s a clip.mp4'\n"; got != want {
+		t.Errorf("concatEntry = %q, want %q", got, want)
+	}
+}
+
+func TestBuildFileList(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := "/run"
+	tests := []struct {
+		name   string
+		plan   mergePlan
+		inputs []string
+		want   string
+	}{
+		{
+			name: "transitions and bumpers",
+			plan: mergePlan{
+				intro:       &card{video: run + "/text_intro.mp4"},
+				transitions: map[int]card{1: {video: run + "/text_transition_001.mp4"}, 2: {video: run + "/text_transition_002.mp4"}},
+				bumper:      run + "/bumper.mp4",
+				bumperEvery: 2,
+				outro:       &card{video: run + "/text_outro.mp4"},
+			},
+			inputs: []string{"/v/a.mp4", "/v/b.mp4", "/v/c.mp4", "/v/d.mp4"},
+			want: "file '/run/text_intro.mp4'\n" +
+				"file '/v/a.mp4'\n" +
+				"file '/run/text_transition_001.mp4'\n" +
+				"file '/v/b.mp4'\n" +
+				"file '/run/bumper.mp4'\n" +
+				"file '/run/text_transition_002.mp4'\n" +
+				"file '/v/c.mp4'\n" +
+				"file '/v/d.mp4'\n" +
+				"file '/run/text_outro.mp4'\n",
+		},
+		{
+			name:   "quoting",
+			plan:   mergePlan{transitions: map[int]card{1: {video: run + "/text_transition_001.mp4"}}},
+			inputs: []string{"/v/it's.mp4", "/v/a 'b' c.mp4"},
+			want: "file '/v/it'\\''s.mp4'\n" +
+				"file '/run/text_transition_001.mp4'\n" +
+				"file '/v/a '\\''b'\\'' c.mp4'\n",
+		},
+		{
+			name:   "explicit file list",
+			plan:   mergePlan{transitions: map[int]card{}},
+			inputs: []string{"clips/a.mp4", "https://example.com/b.mp4?sig=1"},
+			want: "file '" + filepath.Join(cwd, "clips", "a.mp4") + "'\n" +
+				"file 'https://example.com/b.mp4?sig=1'\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.plan.videos = tt.inputs
+			got, err := buildFileList(tt.plan.segments(tt.inputs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("buildFileList:\n got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeResultFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		animation string
+		want      int
+	}{
+		// Two one-second cards at 10 fps.
+		{"still cards", "", 2},
+		{"animated cards", AnimationSlideUp, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, &fakeRunner{}, 3)
+			config.Text.TextAnimation = tt.animation
+			result, err := MergeWithResult(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Frames != tt.want {
+				t.Errorf("Frames = %d, want %d", result.Frames, tt.want)
+			}
+		})
+	}
+}
+
+func TestXfadeConcatArgs(t *testing.T) {
+	runner := &fakeRunner{}
+	config := testConfig(t, runner, 2)
+	config.TransitionType = TransitionXfade
+	config.ConcatArgs = []string{"-movflags", "+faststart"}
+	if _, err := Merge(config); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := runner.ffmpegCalls()
+	got := baseNames(calls[len(calls)-1])
+	if tail := got[len(got)-3:]; !slices.Equal(tail, []string{"-movflags", "+faststart", "out.mp4"}) {
+		t.Errorf("crossfade args end %q, want concatArgs before the output", tail)
+	}
+}
+
+func TestDryRunEstimateTrims(t *testing.T) {
+	config := testConfig(t, &fakeRunner{}, 3)
+	// Each fake clip lasts 5s: keep 2s of the first, the last 1.5s of
+	// the second, and all of the third, plus two 1s cards.
+	config.Source[0].Start, config.Source[0].End = "1", "3"
+	config.Source[1].Start = "3.5"
+	var out bytes.Buffer
+	if err := DryRun(config, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Estimated output: 11s across") {
+		t.Errorf("dry run doesn't estimate 2+1.5+5+2 = 10.5s, rounded to 11s:\n%s", out.String())
+	}
+}
+
+func TestTrimmedDuration(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       float64
+	}{
+		{"", "", 10},
+		{"2", "", 8},
+		{"", "4", 4},
+		{"2", "4", 2},
+		// An end past the clip stops at the clip's end.
+		{"2", "20", 8},
+		{"12", "", 0},
+	}
+	for _, tt := range tests {
+		source := SourceEntry{Path: "clip.mp4", Start: tt.start, End: tt.end}
+		if got := trimmedDuration(source, 10); got != tt.want {
+			t.Errorf("trimmedDuration(start %q, end %q) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
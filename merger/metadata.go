@@ -0,0 +1,43 @@
+package merger
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// set reports whether any tag is configured.
+func (m MetadataConfig) set() bool {
+	return m.Title != "" || m.Artist != "" || m.Comment != ""
+}
+
+// args returns the -metadata options for the configured tags.
+func (m MetadataConfig) args() []string {
+	var args []string
+	for _, tag := range []struct{ key, value string }{
+		{"title", m.Title},
+		{"artist", m.Artist},
+		{"comment", m.Comment},
+	} {
+		if tag.value != "" {
+			args = append(args, "-metadata", tag.key+"="+tag.value)
+		}
+	}
+	return args
+}
+
+// metadataSource returns the source clip whose metadata is preserved,
+// matching MetadataSource by path, base name, or index.
+func metadataSource(config Config, videos []string) (string, error) {
+	if config.MetadataSource == "" {
+		return videos[0], nil
+	}
+	for i, video := range videos {
+		for _, key := range []string{video, filepath.Base(sourcePath(video)), strconv.Itoa(i)} {
+			if key == config.MetadataSource {
+				return video, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("metadataSource '%s' matches no source clip", config.MetadataSource)
+}
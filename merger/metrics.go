@@ -0,0 +1,112 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts merges for Prometheus. Set Config.Metrics to one from
+// NewMetrics, shared by every merge, to record them; a nil Metrics
+// records nothing.
+type Metrics struct {
+	merges *prometheus.CounterVec
+	frames prometheus.Counter
+	ffmpeg prometheus.Histogram
+	output prometheus.Histogram
+}
+
+// NewMetrics creates merge metrics and registers them with reg:
+//
+//   - video_merger_merges_total, by result: "success", "config",
+//     "sources", "font", "ffmpeg", "remote", "frames", "canceled", or
+//     "other"
+//   - video_merger_frames_generated_total, card frame images drawn; a
+//     still card counts once
+//   - video_merger_ffmpeg_duration_seconds, how long each ffmpeg run took
+//   - video_merger_output_bytes, the size of each merged output
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		merges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "video_merger",
+			Name:      "merges_total",
+			Help:      "Merges run, by result.",
+		}, []string{"result"}),
+		frames: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "video_merger",
+			Name:      "frames_generated_total",
+			Help:      "Card frame images drawn.",
+		}),
+		ffmpeg: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "video_merger",
+			Name:      "ffmpeg_duration_seconds",
+			Help:      "Time each ffmpeg run took.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		}),
+		output: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "video_merger",
+			Name:      "output_bytes",
+			Help:      "Size of each merged output.",
+			Buckets:   prometheus.ExponentialBuckets(1<<20, 4, 10),
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.merges, m.frames, m.ffmpeg, m.output} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ffmpegRun records one ffmpeg run that started at start.
+func (m *Metrics) ffmpegRun(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.ffmpeg.Observe(time.Since(start).Seconds())
+}
+
+// merged records a finished merge.
+func (m *Metrics) merged(result Result, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.merges.WithLabelValues(failureLabel(err)).Inc()
+		return
+	}
+	m.merges.WithLabelValues("success").Inc()
+	m.output.Observe(float64(result.OutputBytes))
+}
+
+// framesDrawn records n card frame images drawn, whether or not the merge
+// goes on to succeed.
+func (m *Metrics) framesDrawn(n int) {
+	if m == nil {
+		return
+	}
+	m.frames.Add(float64(n))
+}
+
+// failureLabel names the kind of err for the merges_total result label.
+func failureLabel(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrConfigInvalid):
+		return "config"
+	case errors.Is(err, ErrNoSources):
+		return "sources"
+	case errors.Is(err, ErrFontLoad):
+		return "font"
+	case errors.Is(err, ErrFFmpeg):
+		return "ffmpeg"
+	case errors.Is(err, ErrRemote):
+		return "remote"
+	case errors.Is(err, ErrTooManyFrames):
+		return "frames"
+	}
+	return "other"
+}
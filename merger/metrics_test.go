@@ -0,0 +1,27 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsCountDrawnFrames(t *testing.T) {
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig(t, &fakeRunner{}, 3)
+	config.Metrics = metrics
+	if _, err := MergeWithResult(config); err != nil {
+		t.Fatal(err)
+	}
+	// Two still cards, drawn once each even though each lasts 10 frames.
+	if got := testutil.ToFloat64(metrics.frames); got != 2 {
+		t.Errorf("frames_generated_total = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.merges.WithLabelValues("success")); got != 1 {
+		t.Errorf(`merges_total{result="success"} = %v, want 1`, got)
+	}
+}
@@ -0,0 +1,71 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	MusicMix     = "mix"
+	MusicReplace = "replace"
+)
+
+// musicFilter returns the filtergraph that lays the music (input 1) under
+// or in place of the merged video's audio (input 0), ending at [a].
+func musicFilter(music MusicConfig) string {
+	volume := "[1:a]volume=" + strconv.FormatFloat(music.Volume, 'f', -1, 64)
+	if music.Mode == MusicReplace {
+		// Padding keeps short music from ending the output early; -shortest
+		// then stops at the end of the video.
+		return volume + ",apad[a]"
+	}
+	if music.Duck {
+		// The clips' own audio drives a compressor on the music, so speech
+		// stays audible over it.
+		return volume + "[m];[0:a]asplit[main][sc];[m][sc]sidechaincompress=threshold=0.05:ratio=8[ducked];" +
+			"[main][ducked]amix=inputs=2:duration=first:normalize=0[a]"
+	}
+	return volume + "[m];[0:a][m]amix=inputs=2:duration=first:normalize=0[a]"
+}
+
+// addMusic writes input to output with the configured music track added.
+// The video stream is copied; only the audio is re-encoded.
+func addMusic(config Config, input, output string) error {
+	duration, _ := probeDuration(config, input)
+
+	args := []string{"-y", "-progress", "pipe:1", "-i", input}
+	if config.Music.Loop {
+		args = append(args, "-stream_loop", "-1")
+	}
+	args = append(args, "-i", config.Music.Path,
+		"-filter_complex", musicFilter(config.Music),
+		"-map", "0:v", "-map", "[a]",
+		"-c:v", "copy", "-c:a", config.audioCodec(config.outputFormat()))
+	args = append(args, config.threadArgs()...)
+	args = append(args, "-shortest", output)
+	return runWithProgress(config, args, duration)
+}
+
+func validateMusic(config Config) []error {
+	music := config.Music
+	if music.Path == "" {
+		return nil
+	}
+	var errs []error
+	if _, err := os.Stat(music.Path); err != nil {
+		errs = append(errs, fmt.Errorf("music.path '%s' is not readable: %w", music.Path, err))
+	}
+	if music.Volume < 0 {
+		errs = append(errs, fmt.Errorf("music.volume must be >= 0, got %v", music.Volume))
+	}
+	switch music.Mode {
+	case "", MusicMix, MusicReplace:
+	default:
+		errs = append(errs, fmt.Errorf("music.mode must be %q or %q, got %q", MusicMix, MusicReplace, music.Mode))
+	}
+	if config.audioCodec(config.outputFormat()) == "" {
+		errs = append(errs, fmt.Errorf("%s output has no audio, so music can't be added", config.outputFormat()))
+	}
+	return errs
+}
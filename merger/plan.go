@@ -0,0 +1,77 @@
+package merger
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DryRun writes the merge plan for config to w without generating frames or
+// running ffmpeg. Missing source videos are listed and reported as an error.
+func DryRun(config Config, w io.Writer) error {
+	config = config.WithDefaults()
+	sources, err := resolveSources(config)
+	if err != nil {
+		return err
+	}
+	videos := paths(sources)
+	output := resolveOutput(config, len(videos))
+
+	var missing []string
+	for _, video := range videos {
+		if !sourceExists(config, video) {
+			missing = append(missing, video)
+		}
+	}
+
+	// Matching the frame to the sources needs ffprobe and readable sources;
+	// without them the card lengths are unknown.
+	frame, frameErr := resolveFrame(config, videos)
+	if frameErr == nil {
+		config.Frame = frame
+	}
+	plan := planMerge(config, sources, config.Dest.IntermediateTextDir)
+
+	fmt.Fprintln(w, "Dry run: merge plan")
+	if frameErr != nil {
+		fmt.Fprintf(w, "Frame: unknown (%v)\n", frameErr)
+	} else {
+		fmt.Fprintf(w, "Frame: %dx%d at %d fps\n", config.Frame.Width, config.Frame.Height, config.Frame.Rate)
+		if rate := config.outputRate(); rate != config.Frame.Rate {
+			fmt.Fprintf(w, "Output rate: %d fps\n", rate)
+		}
+	}
+	fmt.Fprintln(w, "Concat order:")
+	if plan.intro != nil {
+		fmt.Fprintf(w, "  [intro] %q (%d frames)\n", plan.intro.text, plan.intro.frames)
+	}
+	for i, c := range plan.toc {
+		fmt.Fprintf(w, "  [contents %d/%d] %d lines (%d frames)\n", i+1, len(plan.toc), strings.Count(c.text, "\n")+1, c.frames)
+	}
+	for i, video := range videos {
+		if plan.bumperBefore(i) {
+			fmt.Fprintf(w, "  [bumper] %s\n", config.Bumper.Path)
+		}
+		if t, ok := plan.transitions[i]; ok {
+			fmt.Fprintf(w, "  [transition %d] %q (%d frames)\n", i, t.text, t.frames)
+		}
+		fmt.Fprintf(w, "  [video %d] %s\n", i, video)
+	}
+	if plan.outro != nil {
+		fmt.Fprintf(w, "  [outro] %q (%d frames)\n", plan.outro.text, plan.outro.frames)
+	}
+	fmt.Fprintln(w, "Output:", output)
+	if estimate, ok := estimateDuration(config, plan, sources); ok {
+		fmt.Fprintf(w, "Estimated output: %s across %d clips + %d transitions\n",
+			estimate, len(plan.videos), len(plan.transitions))
+	}
+
+	if len(missing) > 0 {
+		fmt.Fprintln(w, "Missing source videos:")
+		for _, video := range missing {
+			fmt.Fprintf(w, "  %s\n", video)
+		}
+		return fmt.Errorf("%d source video(s) missing", len(missing))
+	}
+	return nil
+}
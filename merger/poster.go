@@ -0,0 +1,56 @@
+package merger
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// posterPath is where the poster for output goes: beside it, with the
+// extension replaced by .jpg.
+func posterPath(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".jpg"
+}
+
+// posterTime resolves at, a percentage of duration ("50%") or a time
+// ("00:01:30", "90"), to seconds.
+func posterTime(at string, duration float64) (float64, error) {
+	if pct, ok := strings.CutSuffix(at, "%"); ok {
+		v, err := strconv.ParseFloat(pct, 64)
+		if err != nil || v < 0 || v > 100 {
+			return 0, fmt.Errorf("invalid percentage '%s'", at)
+		}
+		return duration * v / 100, nil
+	}
+	return parseTimestamp(at)
+}
+
+// writePoster extracts one frame of output as a JPEG and returns its path.
+func writePoster(config Config, output string) (string, error) {
+	at := config.Poster.At
+	if at == "" {
+		at = "50%"
+	}
+	var duration float64
+	if strings.HasSuffix(at, "%") {
+		var err error
+		if duration, err = probeDuration(config, output); err != nil {
+			return "", err
+		}
+	}
+	seconds, err := posterTime(at, duration)
+	if err != nil {
+		return "", err
+	}
+
+	poster := posterPath(output)
+	cmd := config.ffmpegCommand("-y", "-ss", strconv.FormatFloat(seconds, 'f', 3, 64),
+		"-i", output, "-frames:v", "1", "-q:v", "2", poster)
+	cmd.Stdout = config.logger().FFmpegOutput()
+	cmd.Stderr = config.logger().FFmpegOutput()
+	if err := config.run(cmd); err != nil {
+		return "", err
+	}
+	return poster, nil
+}
@@ -0,0 +1,59 @@
+package merger
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strconv"
+
+	"github.com/fogleman/gg"
+)
+
+// previewStart returns where source's part in the merge begins, so a
+// trimmed clip is previewed from its start time.
+func previewStart(source SourceEntry) float64 {
+	if start, _, err := trimRange(source); err == nil {
+		return start
+	}
+	return 0
+}
+
+// extractPreviews saves the first frame of the clip after each card that
+// previews one, fitted to the frame, and sets it as the card's
+// background. A card whose frame can't be extracted keeps the configured
+// background.
+func extractPreviews(config Config, cards []card) {
+	for i, c := range cards {
+		if c.preview == "" {
+			continue
+		}
+		path := filepath.Join(c.dir, "preview_"+c.name+".png")
+		args := []string{"-y"}
+		if c.previewAt > 0 {
+			args = append(args, "-ss", strconv.FormatFloat(c.previewAt, 'f', -1, 64))
+		}
+		args = append(args, "-i", c.preview, "-frames:v", "1", "-vf", fitFilter(config), path)
+		cmd := config.ffmpegCommand(args...)
+		cmd.Stdout = config.logger().FFmpegOutput()
+		cmd.Stderr = config.logger().FFmpegOutput()
+		if err := config.run(cmd); err != nil {
+			config.logger().Infof("Warning: could not extract a preview frame from '%s' (%v); using the card background", c.preview, err)
+			continue
+		}
+		cards[i].background = path
+	}
+}
+
+// loadPreview loads a card's preview frame at the render size, darkened
+// by dim.
+func loadPreview(path string, render Config, dim float64) (image.Image, error) {
+	img, err := gg.LoadImage(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading preview frame '%s': %w", path, err)
+	}
+	dc := gg.NewContextForImage(scaleImage(img, render.Frame.Width, render.Frame.Height))
+	dc.SetRGBA(0, 0, 0, dim)
+	dc.DrawRectangle(0, 0, float64(dc.Width()), float64(dc.Height()))
+	dc.Fill()
+	return dc.Image(), nil
+}
@@ -0,0 +1,129 @@
+package merger
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"github.com/fogleman/gg"
+)
+
+// Contact sheet layout: thumbnails this wide, this many to a row, with a
+// gap between them.
+const (
+	sheetThumbWidth = 320
+	sheetColumns    = 4
+	sheetGap        = 8
+)
+
+// PreviewCards renders the last frame of every card in the merge, in
+// concat order, to dir as card_<name>.png, and a contact sheet of them
+// all as contact_sheet.png. Frames are drawn fully opaque so a fade-out
+// doesn't hide the caption. No video is encoded; ffmpeg only runs to
+// extract preview backgrounds when those are enabled. It returns the
+// paths written, the contact sheet last.
+func PreviewCards(config Config, dir string) ([]string, error) {
+	config = config.WithDefaults()
+	config.probes = newProbeCache()
+	if errs := ValidateConfig(config); len(errs) > 0 {
+		return nil, withKind(ErrConfigInvalid, fmt.Errorf("invalid config: %w", errors.Join(errs...)))
+	}
+	sources, err := resolveSources(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Frame, err = resolveFrame(config, paths(sources)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating preview directory '%s': %w", dir, err)
+	}
+
+	fonts, err := loadFonts(config.Font)
+	if err != nil {
+		return nil, err
+	}
+	looks, err := newCardLooks(config, fonts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Preview backgrounds are extracted beside the cards' frames, so the
+	// cards are planned in a temp dir that's removed afterwards, leaving
+	// only the card images in dir.
+	work, err := os.MkdirTemp("", "video_merger_preview_*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp directory: %w", err)
+	}
+	defer os.RemoveAll(work)
+	cards := planMerge(config, sources, work).cards()
+	if len(cards) == 0 {
+		return nil, errors.New("the merge has no cards to preview")
+	}
+	if config.PreviewBackground.Enabled {
+		extractPreviews(config, cards)
+	}
+
+	var paths []string
+	var thumbs []image.Image
+	render := config.supersampled()
+	for _, c := range cards {
+		// Each card gets its own context, since an image that isn't
+		// scaled is the context's own and is kept for the sheet.
+		dc := gg.NewContext(render.Frame.Width, render.Frame.Height)
+		look := looks[c.style]
+		face := look.fonts.face(look.render.Font.Size)
+		if c.fit {
+			face = fitFace(look.render, look.fonts, c.text)
+		}
+		style := look.style
+		if c.background != "" {
+			bg, err := loadPreview(c.background, look.render, config.PreviewBackground.Dim)
+			if err != nil {
+				config.logger().Infof("Warning: %v; using the card background", err)
+			} else {
+				style.bgImage = bg
+			}
+		}
+		renderFrame(dc, look.render, face, frameJob{card: c, frame: c.frames - 1, alpha: 1}, style)
+		img := scaleImage(dc.Image(), config.Frame.Width, config.Frame.Height)
+		path := filepath.Join(dir, "card_"+c.name+".png")
+		if err := gg.SavePNG(path, img); err != nil {
+			return nil, fmt.Errorf("error saving card preview '%s': %w", path, err)
+		}
+		paths = append(paths, path)
+		thumbs = append(thumbs, img)
+	}
+
+	sheet := filepath.Join(dir, "contact_sheet.png")
+	if err := gg.SavePNG(sheet, contactSheet(config, thumbs)); err != nil {
+		return nil, fmt.Errorf("error saving contact sheet '%s': %w", sheet, err)
+	}
+	return append(paths, sheet), nil
+}
+
+// contactSheet lays frames out in rows of sheetColumns, shrunk to
+// sheetThumbWidth, on the card background color.
+func contactSheet(config Config, frames []image.Image) image.Image {
+	w := min(sheetThumbWidth, config.Frame.Width)
+	h := max(1, w*config.Frame.Height/config.Frame.Width)
+	cols := min(sheetColumns, len(frames))
+	rows := (len(frames) + cols - 1) / cols
+
+	dc := gg.NewContext(cols*w+(cols+1)*sheetGap, rows*h+(rows+1)*sheetGap)
+	bg, err := parseColor(config.Text.Background)
+	if err != nil {
+		bg = color.Black
+	}
+	dc.SetColor(bg)
+	dc.Clear()
+	for i, frame := range frames {
+		x := sheetGap + i%cols*(w+sheetGap)
+		y := sheetGap + i/cols*(h+sheetGap)
+		dc.DrawImage(scaleImage(frame, w, h), x, y)
+	}
+	return dc.Image()
+}
@@ -0,0 +1,31 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPreviewCardsWritesOnlyCardImages(t *testing.T) {
+	config := testConfig(t, &fakeRunner{}, 3)
+	config.PreviewBackground.Enabled = true
+	dir := filepath.Join(t.TempDir(), "previews")
+	if _, err := PreviewCards(config, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	// The fake preview frames aren't images, so the cards fall back to
+	// their background, but the extracted files mustn't be left behind.
+	if want := []string{"card_001.png", "card_002.png", "contact_sheet.png"}; !slices.Equal(got, want) {
+		t.Errorf("preview dir holds %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,322 @@
+package merger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeCache remembers ffprobe output for the duration of one run, so the
+// preflight estimate, normalization, and progress reporting probe each
+// file once.
+type probeCache struct {
+	mu  sync.Mutex
+	out map[string][]byte
+}
+
+func newProbeCache() *probeCache {
+	return &probeCache{out: map[string][]byte{}}
+}
+
+// runProbe runs ffprobe with args, reusing a cached result when the same
+// probe has already succeeded.
+func (config Config) runProbe(args ...string) ([]byte, error) {
+	key := strings.Join(args, "\x00")
+	if config.probes != nil {
+		config.probes.mu.Lock()
+		out, ok := config.probes.out[key]
+		config.probes.mu.Unlock()
+		if ok {
+			return out, nil
+		}
+	}
+
+	var stdout bytes.Buffer
+	cmd := config.ffprobeCommand(args...)
+	cmd.Stdout = &stdout
+	if err := config.run(cmd); err != nil {
+		return nil, err
+	}
+	out := stdout.Bytes()
+	if config.probes != nil {
+		config.probes.mu.Lock()
+		config.probes.out[key] = out
+		config.probes.mu.Unlock()
+	}
+	return out, nil
+}
+
+type videoInfo struct {
+	Codec  string
+	Width  int
+	Height int
+	Rate   float64
+}
+
+func probeVideo(config Config, path string) (videoInfo, error) {
+	out, err := config.runProbe("-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,width,height,r_frame_rate", "-of", "json", path)
+	if err != nil {
+		return videoInfo{}, fmt.Errorf("error probing '%s': %w", path, err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecName  string `json:"codec_name"`
+			Width      int    `json:"width"`
+			Height     int    `json:"height"`
+			RFrameRate string `json:"r_frame_rate"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return videoInfo{}, fmt.Errorf("error parsing ffprobe output for '%s': %w", path, err)
+	}
+	if len(probe.Streams) == 0 {
+		return videoInfo{}, fmt.Errorf("no video stream in '%s'", path)
+	}
+
+	st := probe.Streams[0]
+	return videoInfo{
+		Codec:  st.CodecName,
+		Width:  st.Width,
+		Height: st.Height,
+		Rate:   parseFrameRate(st.RFrameRate),
+	}, nil
+}
+
+// probeDuration returns the container duration of path in seconds.
+func probeDuration(config Config, path string) (float64, error) {
+	out, err := config.runProbe("-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", path)
+	if err != nil {
+		return 0, fmt.Errorf("error probing duration of '%s': %w", path, err)
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing duration of '%s': %w", path, err)
+	}
+	return duration, nil
+}
+
+// parseFrameRate parses ffprobe rates such as "30000/1001" or "25".
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// resolveFrame returns config.Frame with its size and rate taken from the
+// source video chosen by frame.auto, or unchanged when it's unset.
+func resolveFrame(config Config, videos []string) (FrameConfig, error) {
+	frame := config.Frame
+	if frame.Auto == "" {
+		return frame, nil
+	}
+
+	candidates := videos[:1]
+	if frame.Auto == FrameAutoLargest {
+		candidates = videos
+	}
+	var best videoInfo
+	for _, video := range candidates {
+		info, err := probeVideo(config, video)
+		if err != nil {
+			return FrameConfig{}, err
+		}
+		if info.Width*info.Height > best.Width*best.Height {
+			best = info
+		}
+	}
+	if best.Width <= 0 || best.Height <= 0 || best.Rate <= 0 {
+		return FrameConfig{}, fmt.Errorf("error matching frame to sources: no usable size and rate found")
+	}
+
+	frame.Width, frame.Height = best.Width, best.Height
+	frame.Rate = int(math.Round(best.Rate))
+	if 2*config.Text.Margin >= frame.Width || 2*config.Text.Margin >= frame.Height {
+		return FrameConfig{}, fmt.Errorf("text.margin %d doesn't leave room for text in a %dx%d frame",
+			config.Text.Margin, frame.Width, frame.Height)
+	}
+	return frame, nil
+}
+
+// outputRate returns the frame rate every segment is converted to.
+func (config Config) outputRate() int {
+	if config.OutputRate > 0 {
+		return config.OutputRate
+	}
+	return config.Frame.Rate
+}
+
+// normalizes reports whether the merge re-encodes inputs to a common
+// format: Normalize or OutputRate is set and some input differs from the
+// format the cards are encoded in.
+func normalizes(config Config, inputs []string) (bool, error) {
+	if !config.Normalize && config.OutputRate <= 0 {
+		return false, nil
+	}
+	return needsNormalize(config, inputs)
+}
+
+// needsNormalize reports whether any video differs from the format the
+// transition clips are encoded in, which breaks stream-copy concat.
+func needsNormalize(config Config, videos []string) (bool, error) {
+	for _, video := range videos {
+		info, err := probeVideo(config, video)
+		if err != nil {
+			return false, err
+		}
+		if info.Codec != probedCodecNames[config.videoCodec(config.intermediateFormat())] || info.Width != config.Frame.Width || info.Height != config.Frame.Height ||
+			math.Abs(info.Rate-float64(config.outputRate())) > 0.01 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func probeAudio(config Config, path string) (AudioConfig, error) {
+	out, err := config.runProbe("-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=sample_rate,channel_layout", "-of", "json", path)
+	if err != nil {
+		return AudioConfig{}, fmt.Errorf("error probing audio of '%s': %w", path, err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			SampleRate    string `json:"sample_rate"`
+			ChannelLayout string `json:"channel_layout"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return AudioConfig{}, fmt.Errorf("error parsing ffprobe output for '%s': %w", path, err)
+	}
+	if len(probe.Streams) == 0 {
+		return AudioConfig{}, nil
+	}
+
+	rate, _ := strconv.Atoi(probe.Streams[0].SampleRate)
+	return AudioConfig{SampleRate: rate, ChannelLayout: probe.Streams[0].ChannelLayout}, nil
+}
+
+// resolveAudio fills unset audio settings from the first video, falling
+// back to 44.1kHz stereo when it can't be probed.
+func resolveAudio(config Config, videos []string) AudioConfig {
+	audio := config.Audio
+	if (audio.SampleRate == 0 || audio.ChannelLayout == "") && len(videos) > 0 {
+		if probed, err := probeAudio(config, videos[0]); err == nil {
+			if audio.SampleRate == 0 {
+				audio.SampleRate = probed.SampleRate
+			}
+			if audio.ChannelLayout == "" {
+				audio.ChannelLayout = probed.ChannelLayout
+			}
+		}
+	}
+	if audio.SampleRate == 0 {
+		audio.SampleRate = 44100
+	}
+	if audio.ChannelLayout == "" {
+		audio.ChannelLayout = "stereo"
+	}
+	return audio
+}
+
+// fitFilter scales a clip to the frame size using config.Fit.
+func fitFilter(config Config) string {
+	w, h := config.Frame.Width, config.Frame.Height
+	switch config.Fit {
+	case FitPad:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=%s,setsar=1",
+			w, h, w, h, ffmpegColor(config.PadColor))
+	case FitCrop:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,setsar=1", w, h, w, h)
+	default:
+		return fmt.Sprintf("scale=%d:%d,setsar=1", w, h)
+	}
+}
+
+// ffmpegColor converts a config color to ffmpeg's 0xRRGGBBAA form,
+// falling back to black for an unset or invalid color.
+func ffmpegColor(s string) string {
+	c, err := parseColor(s)
+	if err != nil {
+		return "black"
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("0x%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+func normalizeVideo(config Config, input, output string) error {
+	filter := fmt.Sprintf("%s,fps=%d", fitFilter(config), config.outputRate())
+	args := append([]string{"-y"}, config.hwaccelArgs()...)
+	args = append(args, "-i", input, "-vf", filter)
+	args = append(args, config.intermediateEncoderArgs()...)
+	cmd := config.ffmpegCommand(append(args, output)...)
+	cmd.Stdout = config.logger().FFmpegOutput()
+	cmd.Stderr = config.logger().FFmpegOutput()
+	return config.run(cmd)
+}
+
+// estimateDuration sums the probed durations of the parts of sources
+// that are merged, the bumpers, and the card lengths in plan. It returns false when ffprobe isn't available or a source can't
+// be probed.
+func estimateDuration(config Config, plan mergePlan, sources []SourceEntry) (time.Duration, bool) {
+	if err := config.lookPath(config.ffprobePath()); err != nil {
+		return 0, false
+	}
+	var seconds float64
+	for _, source := range sources {
+		d, err := probeDuration(config, source.Path)
+		if err != nil {
+			return 0, false
+		}
+		seconds += trimmedDuration(source, d)
+	}
+	for _, c := range plan.cards() {
+		seconds += float64(c.frames) / float64(config.Frame.Rate)
+	}
+	if n := plan.bumpers(); n > 0 {
+		d, err := probeDuration(config, config.Bumper.Path)
+		if err != nil {
+			return 0, false
+		}
+		seconds += float64(n) * d
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second), true
+}
+
+// trimmedDuration returns how much of a source lasting duration seconds
+// its start and end keep.
+func trimmedDuration(source SourceEntry, duration float64) float64 {
+	start, end, err := trimRange(source)
+	if err != nil {
+		return duration
+	}
+	if end >= 0 && end < duration {
+		duration = end
+	}
+	return math.Max(0, duration-start)
+}
+
+func (config Config) logEstimate(plan mergePlan, sources []SourceEntry) {
+	if estimate, ok := estimateDuration(config, plan, sources); ok {
+		config.logger().Infof("Estimated output: %s across %d clips + %d transitions",
+			estimate, len(plan.videos), len(plan.transitions))
+	}
+}
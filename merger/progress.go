@@ -0,0 +1,61 @@
+package merger
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	StageTransition = "transition"
+	StageMerge      = "merge"
+)
+
+// Progress reports how far a merge has got. During StageTransition,
+// Current and Total count transition clips; during StageMerge, Percent is
+// the share of the output duration encoded so far.
+type Progress struct {
+	Stage   string
+	Current int
+	Total   int
+	Percent float64
+}
+
+// ProgressFunc receives progress updates while Merge runs.
+type ProgressFunc func(Progress)
+
+func (config Config) report(p Progress) {
+	if config.Progress != nil {
+		config.Progress(p)
+	}
+}
+
+// readFFmpegProgress parses the key=value stream written by ffmpeg's
+// -progress option and reports the encoded share of totalSeconds.
+func readFFmpegProgress(r io.Reader, totalSeconds float64, report func(Progress)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_ms":
+			// Despite the name, ffmpeg reports microseconds here.
+			us, err := strconv.ParseFloat(value, 64)
+			if err != nil || totalSeconds <= 0 {
+				continue
+			}
+			percent := us / 1e6 / totalSeconds * 100
+			if percent > 100 {
+				percent = 100
+			}
+			report(Progress{Stage: StageMerge, Percent: percent})
+		case "progress":
+			if value == "end" {
+				report(Progress{Stage: StageMerge, Percent: 100})
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package merger
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources may be URLs as well as paths. ffmpeg and ffprobe read http and
+// https sources directly; s3 sources are downloaded with the AWS CLI
+// before the merge starts.
+
+// IsRemote reports whether path is an http, https, or s3 URL rather than
+// a local file.
+func IsRemote(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || isS3(path)
+}
+
+func isS3(path string) bool {
+	return strings.HasPrefix(strings.ToLower(path), "s3://")
+}
+
+// sourcePath returns the file path of video, which for a URL is its path
+// without the host or query string, so names and extensions come out the
+// same as for a local file.
+func sourcePath(video string) string {
+	if !IsRemote(video) {
+		return video
+	}
+	u, err := url.Parse(video)
+	if err != nil || u.Path == "" {
+		return video
+	}
+	return u.Path
+}
+
+// concatInputArgs reads listPath with the concat demuxer. The demuxer
+// only opens local files by default, so http sources in the list need the
+// network protocols allowed.
+func concatInputArgs(listPath string) []string {
+	return []string{"-f", "concat", "-safe", "0", "-protocol_whitelist", "file,http,https,tcp,tls,crypto", "-i", listPath}
+}
+
+// splitS3 returns the bucket and key of an s3:// URL.
+func splitS3(path string) (bucket, key string) {
+	bucket, key, _ = strings.Cut(path[len("s3://"):], "/")
+	return bucket, key
+}
+
+func (config Config) awsCommand(args ...string) *exec.Cmd {
+	return exec.CommandContext(config.context(), config.awsPath(), args...)
+}
+
+func (config Config) awsPath() string {
+	if config.AWSPath != "" {
+		return config.AWSPath
+	}
+	return "aws"
+}
+
+// usesS3 reports whether any configured source is an s3:// URL.
+func (config Config) usesS3() bool {
+	return slices.ContainsFunc(config.Source, func(s SourceEntry) bool { return isS3(s.Path) })
+}
+
+// remoteClient checks that http sources exist. The timeout only bounds
+// the check; ffmpeg does the actual reading.
+var remoteClient = &http.Client{Timeout: 30 * time.Second}
+
+// sourceExists reports whether video can be read: a local file that
+// exists, an s3 object the AWS CLI can see, or a URL the server answers
+// with a success status.
+func sourceExists(config Config, video string) bool {
+	switch {
+	case isS3(video):
+		bucket, key := splitS3(video)
+		return config.run(config.awsCommand("s3api", "head-object", "--bucket", bucket, "--key", key)) == nil
+	case IsRemote(video):
+		return urlExists(config, video)
+	}
+	_, err := os.Stat(video)
+	return err == nil
+}
+
+func urlExists(config Config, video string) bool {
+	status := func(method string) int {
+		req, err := http.NewRequestWithContext(config.context(), method, video, nil)
+		if err != nil {
+			return 0
+		}
+		// Some servers don't answer HEAD, so the fallback GET asks for a
+		// single byte rather than the whole clip.
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err := remoteClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	code := status(http.MethodHead)
+	if code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented {
+		code = status(http.MethodGet)
+	}
+	return code >= 200 && code < 400
+}
+
+// downloadS3Sources copies each distinct s3 source into dir and returns
+// the local path of each URL. Downloads keep their object's file name, so
+// captions and base-name keys such as titles still match.
+func downloadS3Sources(config Config, dir string, sources []SourceEntry) (map[string]string, error) {
+	downloads := map[string]string{}
+	for _, source := range sources {
+		if _, ok := downloads[source.Path]; ok || !isS3(source.Path) {
+			continue
+		}
+		local := filepath.Join(dir, strconv.Itoa(len(downloads)), filepath.Base(sourcePath(source.Path)))
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			return nil, fmt.Errorf("error creating download directory: %w", err)
+		}
+		config.logger().Infof("Downloading %s", source.Path)
+		err := config.retry("Downloading "+source.Path, func() error {
+			cmd := config.awsCommand("s3", "cp", "--only-show-errors", source.Path, local)
+			cmd.Stdout = config.logger().FFmpegOutput()
+			cmd.Stderr = config.logger().FFmpegOutput()
+			return config.run(cmd)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error downloading '%s': %w", source.Path, err)
+		}
+		downloads[source.Path] = local
+	}
+	return downloads, nil
+}
+
+// localize returns sources with each downloaded URL replaced by its local
+// path.
+func localize(sources []SourceEntry, downloads map[string]string) []SourceEntry {
+	sources = slices.Clone(sources)
+	for i, source := range sources {
+		if local, ok := downloads[source.Path]; ok {
+			sources[i].Path = local
+		}
+	}
+	return sources
+}
@@ -0,0 +1,110 @@
+package merger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRunner records the commands a merge runs instead of running them.
+// ffprobe answers as if every file were a 5 second 320x180 h264 clip at
+// 10 fps with 48 kHz stereo audio, and ffmpeg writes a placeholder output
+// file so later steps find it.
+type fakeRunner struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (r *fakeRunner) Run(cmd *exec.Cmd) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, slices.Clone(cmd.Args))
+	r.mu.Unlock()
+
+	args := strings.Join(cmd.Args[1:], " ")
+	if filepath.Base(cmd.Args[0]) == "ffprobe" {
+		var out string
+		switch {
+		case strings.Contains(args, "format=duration"):
+			out = "5.0\n"
+		case strings.Contains(args, "codec_type"):
+			out = `{"streams":[{"codec_type":"video","codec_name":"h264","profile":"High","pix_fmt":"yuv420p","width":320,"height":180,"r_frame_rate":"10/1"},` +
+				`{"codec_type":"audio","codec_name":"aac","sample_rate":"48000","channel_layout":"stereo"}]}`
+		case strings.Contains(args, "a:0"):
+			out = `{"streams":[{"sample_rate":"48000","channel_layout":"stereo"}]}`
+		default:
+			out = `{"streams":[{"codec_name":"h264","width":320,"height":180,"r_frame_rate":"10/1"}]}`
+		}
+		if cmd.Stdout != nil {
+			io.WriteString(cmd.Stdout, out)
+		}
+		return nil
+	}
+
+	if output := cmd.Args[len(cmd.Args)-1]; output != "-" && !strings.HasPrefix(output, "pipe:") {
+		if err := os.WriteFile(output, []byte("fake"), 0644); err != nil {
+			return err
+		}
+	}
+	if cmd.Stdout != nil {
+		io.WriteString(cmd.Stdout, "progress=end\n")
+	}
+	return nil
+}
+
+// ffmpegCalls returns the argument lists of the ffmpeg commands run, in
+// order, without the program name.
+func (r *fakeRunner) ffmpegCalls() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var calls [][]string
+	for _, call := range r.calls {
+		if filepath.Base(call[0]) == "ffmpeg" {
+			calls = append(calls, call[1:])
+		}
+	}
+	return calls
+}
+
+// testConfig returns a quiet config that merges n placeholder clips in a
+// temp dir through runner, with one second transition cards at 10 fps.
+func testConfig(t *testing.T, runner Runner, n int) Config {
+	t.Helper()
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Runner = runner
+	config.Logger = &Logger{Level: LogQuiet, Out: io.Discard}
+	config.Font.Path = filepath.Join("..", "font", "Cascadia.ttf")
+	config.Font.Size = 16
+	config.Frame = FrameConfig{Width: 320, Height: 180, Rate: 10}
+	config.Text.Duration = 1
+	config.SkipDiskCheck = true
+	config.Dest.Output = filepath.Join(dir, "out.mp4")
+	config.Dest.IntermediateTextDir = filepath.Join(dir, "work")
+	for i := 1; i <= n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("clip%d.mp4", i))
+		if err := os.WriteFile(path, []byte("fake"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config.Source = append(config.Source, SourceEntry{Path: path})
+	}
+	return config
+}
+
+// baseNames replaces each absolute path in args with its base name, so
+// argument lists naming temp files can be compared exactly.
+func baseNames(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if filepath.IsAbs(arg) {
+			arg = filepath.Base(arg)
+		}
+		out[i] = arg
+	}
+	return out
+}
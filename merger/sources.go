@@ -0,0 +1,438 @@
+package merger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultSourceDir is scanned for videos when neither source nor
+// sourceDir is configured.
+const DefaultSourceDir = "./source"
+
+func (config Config) sourceDir() string {
+	if config.SourceDir != "" {
+		return config.SourceDir
+	}
+	return DefaultSourceDir
+}
+
+// DefaultSourceExtensions are the video extensions scanned for when
+// sourceExtensions isn't configured.
+var DefaultSourceExtensions = []string{".mp4", ".mov", ".avi", ".mkv", ".webm", ".m4v"}
+
+// extensionSet normalizes extensions to lowercase with a leading dot.
+func extensionSet(extensions []string) map[string]bool {
+	if len(extensions) == 0 {
+		extensions = DefaultSourceExtensions
+	}
+	set := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		set[ext] = true
+	}
+	return set
+}
+
+// supportedExtensions returns the normalized extensions in a stable order.
+func supportedExtensions(extensions []string) []string {
+	set := extensionSet(extensions)
+	sorted := make([]string, 0, len(set))
+	for ext := range set {
+		sorted = append(sorted, ext)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// dateRange bounds the modification times of scanned videos. A zero
+// since or until leaves that end open; until is exclusive.
+type dateRange struct {
+	since, until time.Time
+}
+
+func (r dateRange) contains(t time.Time) bool {
+	return (r.since.IsZero() || !t.Before(r.since)) && (r.until.IsZero() || t.Before(r.until))
+}
+
+// parseScanDate parses an RFC 3339 time or a YYYY-MM-DD date in local
+// time. With endOfDay, a date means the midnight after it.
+func parseScanDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s': want RFC 3339 or YYYY-MM-DD", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// dateRange parses the scan config's Since and Until.
+func (scan ScanConfig) dateRange() (dateRange, error) {
+	since, err := parseScanDate(scan.Since, false)
+	if err != nil {
+		return dateRange{}, fmt.Errorf("scan.since: %w", err)
+	}
+	until, err := parseScanDate(scan.Until, true)
+	if err != nil {
+		return dateRange{}, fmt.Errorf("scan.until: %w", err)
+	}
+	return dateRange{since: since, until: until}, nil
+}
+
+// getVideoFiles lists the videos under sourceDir with one of extensions,
+// keeping those modified within dates. It also returns how many matching
+// videos were left out by date.
+func getVideoFiles(sourceDir string, recursive bool, extensions []string, dates dateRange) ([]string, int, error) {
+	var videos []string
+	var skipped int
+
+	allowedExt := extensionSet(extensions)
+	// inRange reports whether d was modified within dates, counting the
+	// ones that weren't.
+	inRange := func(d os.DirEntry) (bool, error) {
+		if dates.since.IsZero() && dates.until.IsZero() {
+			return true, nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return false, err
+		}
+		if !dates.contains(info.ModTime()) {
+			skipped++
+			return false, nil
+		}
+		return true, nil
+	}
+
+	if !recursive {
+		entries, err := os.ReadDir(sourceDir)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading directory: %w", err)
+		}
+		for _, d := range entries {
+			if d.IsDir() {
+				continue
+			}
+			ext := strings.ToLower(filepath.Ext(d.Name()))
+			if !allowedExt[ext] {
+				continue
+			}
+			ok, err := inRange(d)
+			if err != nil {
+				return nil, 0, fmt.Errorf("error reading directory: %w", err)
+			}
+			if ok {
+				videos = append(videos, filepath.Join(sourceDir, d.Name()))
+			}
+		}
+		return videos, skipped, nil
+	}
+
+	err := filepath.WalkDir(sourceDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			ext := strings.ToLower(filepath.Ext(path))
+			if !allowedExt[ext] {
+				return nil
+			}
+			ok, err := inRange(d)
+			if err != nil {
+				return err
+			}
+			if ok {
+				videos = append(videos, filepath.Clean(path))
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("error walking directory: %w", err)
+	}
+
+	return videos, skipped, nil
+}
+
+// ResolveVideos returns the ordered list of videos to merge, either from
+// config.Source or by scanning config.SourceDir.
+func ResolveVideos(config Config) ([]string, error) {
+	sources, err := resolveSources(config)
+	if err != nil {
+		return nil, err
+	}
+	return paths(sources), nil
+}
+
+// resolveSources is ResolveVideos, keeping each entry's trim.
+func resolveSources(config Config) ([]SourceEntry, error) {
+	sources := append([]SourceEntry(nil), config.Source...)
+	if len(sources) == 0 {
+		dates, err := config.Scan.dateRange()
+		if err != nil {
+			return nil, withKind(ErrConfigInvalid, err)
+		}
+		videos, skipped, err := getVideoFiles(config.sourceDir(), config.Scan.Recursive, config.SourceExtensions, dates)
+		if err != nil {
+			return nil, fmt.Errorf("error reading source directory: %w", err)
+		}
+		if len(videos) == 0 && skipped > 0 {
+			return nil, withKind(ErrNoSources, fmt.Errorf("no video files in '%s' were modified in the since/until range (%d filtered out)",
+				config.sourceDir(), skipped))
+		}
+		if len(videos) == 0 {
+			return nil, withKind(ErrNoSources, fmt.Errorf("no video files found in '%s'; supported extensions: %s",
+				config.sourceDir(), strings.Join(supportedExtensions(config.SourceExtensions), ", ")))
+		}
+		if skipped > 0 {
+			config.logger().Infof("Skipped %d video(s) modified outside the since/until range", skipped)
+		}
+		sources = SourcePaths(videos)
+	}
+	if err := sortVideos(sources, config.Scan); err != nil {
+		return nil, fmt.Errorf("error sorting videos: %w", err)
+	}
+	if config.Reverse {
+		slices.Reverse(sources)
+	}
+	if config.DedupeConsecutive {
+		var err error
+		if sources, err = dedupeConsecutive(config, sources); err != nil {
+			return nil, fmt.Errorf("error comparing videos: %w", err)
+		}
+	}
+	return sources, nil
+}
+
+// checkSourcesExist reports every source that can't be found, so a typo in
+// a path fails the merge up front rather than deep inside ffmpeg.
+func checkSourcesExist(config Config, sources []SourceEntry) error {
+	var missing []string
+	for _, source := range sources {
+		if !sourceExists(config, source.Path) {
+			missing = append(missing, source.Path)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return withKind(ErrNoSources, fmt.Errorf("%d source video(s) not found: %s", len(missing), strings.Join(missing, ", ")))
+}
+
+// dedupeConsecutive drops each source identical to the one before it,
+// logging what it removes.
+func dedupeConsecutive(config Config, sources []SourceEntry) ([]SourceEntry, error) {
+	var kept []SourceEntry
+	var prevKey string
+	for i, source := range sources {
+		key, err := dedupeKey(config, source)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && key == prevKey {
+			config.logger().Infof("Skipping duplicate of the previous clip: %s", source.Path)
+			continue
+		}
+		prevKey = key
+		kept = append(kept, source)
+	}
+	return kept, nil
+}
+
+// dedupeKey identifies what a source plays: its file, by path or by
+// content hash, and its trim. URLs are always compared by path.
+func dedupeKey(config Config, source SourceEntry) (string, error) {
+	file := nfc(filepath.Clean(source.Path))
+	if IsRemote(source.Path) {
+		file = source.Path
+	} else if config.DedupeBy == DedupeByContent {
+		var err error
+		if file, err = config.hashes.sum(source.Path); err != nil {
+			return "", err
+		}
+	}
+	return file + "\x00" + source.Start + "\x00" + source.End, nil
+}
+
+// hashCache remembers content hashes, keyed by path, size, and
+// modification time, so the dry run, frame count check, and merge of one
+// run read each file once, while a file changed between watch-mode runs
+// is hashed again.
+type hashCache struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+func newHashCache() *hashCache {
+	return &hashCache{sums: map[string]string{}}
+}
+
+// sum returns the SHA-256 of the file at path, reusing an earlier hash of
+// the same version of it. A nil cache hashes every time.
+func (c *hashCache) sum(path string) (string, error) {
+	if c == nil {
+		return hashFile(path)
+	}
+	key := fileStamp(path)
+	c.mu.Lock()
+	sum, ok := c.sums[key]
+	c.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.sums[key] = sum
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// hashFile returns the SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// nfc returns s in Unicode Normalization Form C. macOS reports file names
+// decomposed (NFD), so "é" from a scan and "é" typed into the config can
+// differ byte for byte; comparing and displaying NFC forms treats them
+// alike. Paths are still opened as discovered, since Linux filesystems
+// don't normalize names.
+func nfc(s string) string {
+	return norm.NFC.String(s)
+}
+
+// sortVideos orders videos by the scan config's key and direction. Ties
+// on time keep their natural name order.
+func sortVideos(videos []SourceEntry, scan ScanConfig) error {
+	var less func(a, b string) bool
+	switch scan.SortBy {
+	case SortByName, "":
+		switch scan.SortMode {
+		case SortNatural, "":
+			less = naturalLess
+		case SortLexical:
+			less = func(a, b string) bool { return a < b }
+		case SortNone:
+		default:
+			return fmt.Errorf("unknown sort mode '%s'", scan.SortMode)
+		}
+	case SortByModTime, SortByCTime:
+		times := make(map[string]time.Time, len(videos))
+		for _, video := range videos {
+			// Unreadable files sort first; they're reported when merged.
+			if info, err := os.Stat(video.Path); err == nil {
+				if scan.SortBy == SortByCTime {
+					times[nfc(video.Path)] = changeTime(info)
+				} else {
+					times[nfc(video.Path)] = info.ModTime()
+				}
+			}
+		}
+		// less sees NFC paths, so the times are keyed by them too.
+		less = func(a, b string) bool {
+			if !times[a].Equal(times[b]) {
+				return times[a].Before(times[b])
+			}
+			return naturalLess(a, b)
+		}
+	default:
+		return fmt.Errorf("unknown sort key '%s'", scan.SortBy)
+	}
+
+	switch scan.Order {
+	case OrderAsc, "":
+	case OrderDesc:
+		if less == nil {
+			slices.Reverse(videos)
+			return nil
+		}
+		asc := less
+		less = func(a, b string) bool { return asc(b, a) }
+	default:
+		return fmt.Errorf("unknown sort order '%s'", scan.Order)
+	}
+
+	if less != nil {
+		sort.SliceStable(videos, func(i, j int) bool {
+			return less(nfc(videos[i].Path), nfc(videos[j].Path))
+		})
+	}
+	return nil
+}
+
+// naturalLess compares strings treating runs of digits as numbers, so
+// "clip2" sorts before "clip10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
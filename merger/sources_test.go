@@ -0,0 +1,84 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Composed (NFC) and decomposed (NFD, as macOS reports) spellings of the
+// same names.
+const (
+	cafeNFC = "caf\u00e9.mp4"
+	cafeNFD = "cafe\u0301.mp4"
+	eteNFC  = "\u00e9t\u00e9.mp4"
+	eteNFD  = "e\u0301te\u0301.mp4"
+)
+
+func TestSortVideosNormalizesNames(t *testing.T) {
+	// Byte for byte, the NFD names sort before "f.mp4" and the NFC ones
+	// after it; compared as NFC, both spellings sort alike.
+	for _, scan := range []ScanConfig{{SortMode: SortNatural}, {SortMode: SortLexical}} {
+		videos := []SourceEntry{{Path: eteNFD}, {Path: "f.mp4"}, {Path: cafeNFC}, {Path: "d.mp4"}}
+		if err := sortVideos(videos, scan); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, v := range videos {
+			got = append(got, nfc(v.Path))
+		}
+		if want := []string{cafeNFC, "d.mp4", "f.mp4", eteNFC}; !slices.Equal(got, want) {
+			t.Errorf("%s sort = %q, want %q", scan.SortMode, got, want)
+		}
+	}
+}
+
+func TestTransitionTextNormalizesTitles(t *testing.T) {
+	tests := []struct {
+		name  string
+		video string
+		key   string
+	}{
+		{"NFD file, NFC key", "/clips/" + cafeNFD, cafeNFC},
+		{"NFC file, NFD key", "/clips/" + cafeNFC, cafeNFD},
+		{"NFD path key", "/clips/" + cafeNFC, "/clips/" + cafeNFD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Titles = map[string]string{tt.key: "Coffee"}
+			if got := transitionText(config, []string{tt.video}, 0); got != "Coffee" {
+				t.Errorf("transitionText = %q, want %q", got, "Coffee")
+			}
+		})
+	}
+
+	// Without a title, the caption shows the name composed.
+	config := DefaultConfig()
+	if got, want := transitionText(config, []string{"/clips/" + cafeNFD}, 0), "Next: "+cafeNFC; got != want {
+		t.Errorf("caption = %q, want %q", got, want)
+	}
+}
+
+func TestHashCacheRehashesChangedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := newHashCache()
+	first, err := cache.sum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := cache.sum(path); again != first || len(cache.sums) != 1 {
+		t.Errorf("second sum = %q with %d entries, want the cached %q", again, len(cache.sums), first)
+	}
+
+	if err := os.WriteFile(path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := cache.sum(path); changed == first {
+		t.Error("a changed file kept its old hash")
+	}
+}
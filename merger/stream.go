@@ -0,0 +1,44 @@
+package merger
+
+import "os"
+
+// StdoutOutput as Dest.Output writes the merged video to stdout.
+const StdoutOutput = "-"
+
+// isStream reports whether output is stdout or a named pipe, which ffmpeg
+// can write to but not seek in.
+func isStream(output string) bool {
+	if output == StdoutOutput {
+		return true
+	}
+	info, err := os.Stat(output)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// streamArgs selects the muxer for a pipe, which can't be probed for a
+// file extension. An mp4 is fragmented, since the index normally written
+// at the start once the video is done can't be seeked back to.
+func streamArgs(format string) []string {
+	args := []string{"-f", format}
+	if format == FormatMP4 {
+		args = append(args, "-movflags", "frag_keyframe+empty_moov+default_base_moof")
+	}
+	return args
+}
+
+// streamOutput stream copies the finished merge at input to output, a
+// named pipe or stdout.
+func streamOutput(config Config, input, output string) error {
+	target := output
+	if output == StdoutOutput {
+		target = "pipe:1"
+	}
+	args := append([]string{"-y", "-i", input, "-map", "0", "-c", "copy"}, streamArgs(config.outputFormat())...)
+	cmd := config.ffmpegCommand(append(args, target)...)
+	cmd.Stdout = config.logger().FFmpegOutput()
+	if output == StdoutOutput {
+		cmd.Stdout = os.Stdout
+	}
+	cmd.Stderr = config.logger().FFmpegOutput()
+	return config.run(cmd)
+}
@@ -0,0 +1,290 @@
+package merger
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/draw"
+)
+
+// frameStyle holds the parsed colors and decoded images shared by every
+// transition frame, so they are prepared once rather than per frame.
+type frameStyle struct {
+	textColor color.Color
+	bgColor   color.Color
+	// bgImage and bgGradient replace bgColor when set.
+	bgImage    image.Image
+	bgGradient gg.Pattern
+	logo       image.Image
+	// strokeColor and shadowColor are nil when the effect is off.
+	strokeColor color.Color
+	shadowColor color.Color
+}
+
+// pickStyles returns the style of the card before each of n videos, as
+// card.style counts them. Every boundary draws from the seeded source
+// whether or not it gets a card, so turning one card off doesn't change
+// the others.
+func (config Config) pickStyles(n int) []int {
+	styles := make([]int, n)
+	if len(config.RandomStyles) == 0 {
+		return styles
+	}
+	r := rand.New(rand.NewSource(config.Seed))
+	for i := range styles {
+		styles[i] = r.Intn(len(config.RandomStyles)) + 1
+	}
+	return styles
+}
+
+// styleNames returns the names of config.Styles in order. card.style
+// counts them after the random styles.
+func (config Config) styleNames() []string {
+	names := make([]string, 0, len(config.Styles))
+	for name := range config.Styles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cardStyles returns every style card.style can refer to, counting from 1.
+func (config Config) cardStyles() []CardStyle {
+	styles := slices.Clone(config.RandomStyles)
+	for _, name := range config.styleNames() {
+		styles = append(styles, config.Styles[name])
+	}
+	return styles
+}
+
+// transitionStyle returns the number, as card.style counts it, of the
+// named style for the card before source, the entry at index in merge
+// order, or 0 when none is asked for. The entry's style wins over a
+// TransitionStyles key.
+func (config Config) transitionStyle(index int, source SourceEntry) int {
+	name := source.Style
+	if name == "" {
+		video := source.Path
+		for _, key := range []string{video, filepath.Base(sourcePath(video)), strconv.Itoa(index)} {
+			if n, ok := config.TransitionStyles[key]; ok {
+				name = n
+				break
+			}
+		}
+	}
+	if i := slices.Index(config.styleNames(), name); name != "" && i >= 0 {
+		return len(config.RandomStyles) + 1 + i
+	}
+	return 0
+}
+
+// withStyle returns config with the overrides of card style s.
+func (config Config) withStyle(s int) Config {
+	if s == 0 {
+		return config
+	}
+	override := config.cardStyles()[s-1]
+	if override.Background != "" {
+		config.Text.Background = override.Background
+	}
+	if override.TextAnimation != "" {
+		config.Text.TextAnimation = override.TextAnimation
+	}
+	if override.Color != "" {
+		config.Text.Color = override.Color
+	}
+	if override.FontPath != "" {
+		config.Font.Path = override.FontPath
+	}
+	if override.FontSize > 0 {
+		config.Font.Size = override.FontSize
+	}
+	return config
+}
+
+// cardLook is what a card style renders with: the supersampled config
+// with the style applied, its prepared frame style, and its fonts.
+type cardLook struct {
+	render Config
+	style  frameStyle
+	fonts  fontChain
+}
+
+// newCardLooks prepares the configured style and every card style,
+// indexed as card.style counts them. Styles without a font of their own
+// share fonts, the configured ones.
+func newCardLooks(config Config, fonts fontChain) ([]cardLook, error) {
+	looks := make([]cardLook, len(config.cardStyles())+1)
+	for i := range looks {
+		styled := config.withStyle(i)
+		style, err := newFrameStyle(styled)
+		if err != nil {
+			return nil, err
+		}
+		look := cardLook{render: styled.supersampled(), style: style, fonts: fonts}
+		if styled.Font.Path != config.Font.Path {
+			if look.fonts, err = loadFonts(styled.Font); err != nil {
+				return nil, err
+			}
+		}
+		looks[i] = look
+	}
+	return looks, nil
+}
+
+// newFrameStyle prepares the style for frames rendered at the
+// supersampled size.
+func newFrameStyle(config Config) (frameStyle, error) {
+	factor := config.Frame.Supersample
+	config = config.supersampled()
+	var style frameStyle
+	var err error
+
+	if style.textColor, err = parseColor(config.Text.Color); err != nil {
+		return frameStyle{}, err
+	}
+	if style.bgColor, err = parseColor(config.Text.Background); err != nil {
+		return frameStyle{}, err
+	}
+
+	if config.Text.Stroke.Color != "" {
+		if style.strokeColor, err = parseColor(config.Text.Stroke.Color); err != nil {
+			return frameStyle{}, fmt.Errorf("text.stroke.color: %w", err)
+		}
+	}
+	if config.Text.Shadow.Color != "" {
+		if style.shadowColor, err = parseColor(config.Text.Shadow.Color); err != nil {
+			return frameStyle{}, fmt.Errorf("text.shadow.color: %w", err)
+		}
+	}
+
+	if config.Text.BackgroundImage != "" {
+		img, err := gg.LoadImage(config.Text.BackgroundImage)
+		if err != nil {
+			return frameStyle{}, fmt.Errorf("error loading background image '%s': %w", config.Text.BackgroundImage, err)
+		}
+		style.bgImage = scaleImage(img, config.Frame.Width, config.Frame.Height)
+	} else if config.Text.Gradient.From != "" {
+		gradient, err := newGradient(config.Text.Gradient, config.Frame.Width, config.Frame.Height)
+		if err != nil {
+			return frameStyle{}, err
+		}
+		style.bgGradient = gradient
+	}
+
+	if config.Logo.Path != "" {
+		logo, err := gg.LoadImage(config.Logo.Path)
+		if err != nil {
+			return frameStyle{}, fmt.Errorf("error loading logo '%s': %w", config.Logo.Path, err)
+		}
+		if factor > 1 {
+			b := logo.Bounds()
+			logo = scaleImage(logo, b.Dx()*factor, b.Dy()*factor)
+		}
+		style.logo = applyOpacity(logo, config.Logo.Opacity)
+	}
+
+	return style, nil
+}
+
+func newGradient(config GradientConfig, width, height int) (gg.Pattern, error) {
+	from, err := parseColor(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("text.gradient.from: %w", err)
+	}
+	to, err := parseColor(config.To)
+	if err != nil {
+		return nil, fmt.Errorf("text.gradient.to: %w", err)
+	}
+
+	var gradient gg.Gradient
+	switch config.Direction {
+	case "horizontal":
+		gradient = gg.NewLinearGradient(0, 0, float64(width), 0)
+	case "vertical", "":
+		gradient = gg.NewLinearGradient(0, 0, 0, float64(height))
+	default:
+		return nil, fmt.Errorf("unknown gradient direction '%s'", config.Direction)
+	}
+	gradient.AddColorStop(0, from)
+	gradient.AddColorStop(1, to)
+	return gradient, nil
+}
+
+// scaleImage stretches img to exactly width x height. Catmull-Rom widens
+// its kernel when shrinking, so a supersampled frame scaled down to the
+// frame size uses every rendered pixel and its text's edges come out
+// smooth.
+func scaleImage(img image.Image, width, height int) image.Image {
+	if b := img.Bounds(); b.Dx() == width && b.Dy() == height {
+		return img
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(out, out.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return out
+}
+
+func drawBackground(dc *gg.Context, style frameStyle) {
+	switch {
+	case style.bgImage != nil:
+		dc.DrawImage(style.bgImage, 0, 0)
+	case style.bgGradient != nil:
+		dc.SetFillStyle(style.bgGradient)
+		dc.DrawRectangle(0, 0, float64(dc.Width()), float64(dc.Height()))
+		dc.Fill()
+	default:
+		dc.SetColor(style.bgColor)
+		dc.Clear()
+	}
+}
+
+// applyOpacity returns a copy of img with every pixel's alpha scaled.
+func applyOpacity(img image.Image, opacity float64) image.Image {
+	if opacity >= 1 {
+		return img
+	}
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			out.Set(x, y, withAlpha(img.At(x, y), opacity))
+		}
+	}
+	return out
+}
+
+// anchorFractions maps a nine-way position name to its horizontal and
+// vertical position within the frame, from 0 (left/top) to 1 (right/bottom).
+var anchorFractions = map[string][2]float64{
+	"top-left":     {0, 0},
+	"top":          {0.5, 0},
+	"top-right":    {1, 0},
+	"left":         {0, 0.5},
+	"center":       {0.5, 0.5},
+	"right":        {1, 0.5},
+	"bottom-left":  {0, 1},
+	"bottom":       {0.5, 1},
+	"bottom-right": {1, 1},
+}
+
+func drawLogo(dc *gg.Context, logo image.Image, config LogoConfig) {
+	anchor, ok := anchorFractions[config.Position]
+	if !ok {
+		anchor = anchorFractions["bottom-right"]
+	}
+	margin := float64(config.Margin)
+	width, height := float64(dc.Width()), float64(dc.Height())
+
+	// Place the anchor point inside the margin, then shift the logo so the
+	// same relative point of the image lands on it.
+	x := margin + anchor[0]*(width-2*margin)
+	y := margin + anchor[1]*(height-2*margin)
+	dc.DrawImageAnchored(logo, int(x), int(y), anchor[0], anchor[1])
+}
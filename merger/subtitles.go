@@ -0,0 +1,89 @@
+package merger
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const SubtitlesSoft = "soft"
+
+// cue is one subtitle, shown from start to end in seconds.
+type cue struct {
+	text       string
+	start, end float64
+}
+
+// planSubtitles returns a cue per source clip showing its caption for the
+// configured duration from the moment the clip itself starts, after any
+// card or bumper before it. Cues never run past their clip.
+func planSubtitles(config Config, plan mergePlan, inputs []string) ([]cue, error) {
+	tl := newTimeline(config)
+	if plan.intro != nil {
+		if _, err := tl.advance(plan.intro.video); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range plan.toc {
+		if _, err := tl.advance(c.video); err != nil {
+			return nil, err
+		}
+	}
+	var cues []cue
+	for i, input := range inputs {
+		if plan.bumperBefore(i) {
+			if _, err := tl.advance(plan.bumper); err != nil {
+				return nil, err
+			}
+		}
+		if c, ok := plan.transitions[i]; ok {
+			if _, err := tl.advance(c.video); err != nil {
+				return nil, err
+			}
+		}
+		start, err := tl.advance(input)
+		if err != nil {
+			return nil, err
+		}
+		// A crossfade overlaps the clip's end, so it's still the main clip
+		// until the next one fades in.
+		end := math.Min(start+config.Subtitles.Duration, tl.t)
+		cues = append(cues, cue{text: transitionText(config, plan.videos, i), start: start, end: end})
+	}
+	if config.MaxDuration > 0 {
+		cues = capCues(cues, config.MaxDuration)
+	}
+	return cues, nil
+}
+
+// srtTime formats seconds as an SRT timestamp, HH:MM:SS,mmm.
+func srtTime(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// writeSubtitles writes cues to path as an SRT file.
+func writeSubtitles(path string, cues []cue) error {
+	var b strings.Builder
+	for i, c := range cues {
+		// A blank line ends a cue, so blank lines in captions are dropped.
+		var lines []string
+		for _, line := range strings.Split(c.text, "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, srtTime(c.start), srtTime(c.end), strings.Join(lines, "\n"))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// subtitleCodec returns the text subtitle codec the output container
+// carries.
+func (config Config) subtitleCodec() string {
+	if config.outputFormat() == FormatWebM {
+		return "webvtt"
+	}
+	return "mov_text"
+}
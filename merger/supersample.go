@@ -0,0 +1,34 @@
+package merger
+
+// supersampled returns config with every card measurement in pixels
+// multiplied by Frame.Supersample, for rendering frames at that multiple
+// of the frame size. Its own Supersample is 1.
+func (config Config) supersampled() Config {
+	f := config.Frame.Supersample
+	if f <= 1 {
+		return config
+	}
+	config.Frame.Width *= f
+	config.Frame.Height *= f
+	config.Frame.Supersample = 1
+	config.Font.Size *= float64(f)
+	config.Text.Margin *= f
+	config.Text.TextX = config.Text.TextX.scaled(f)
+	config.Text.TextY = config.Text.TextY.scaled(f)
+	config.Text.Stroke.Width *= f
+	config.Text.Shadow.OffsetX *= f
+	config.Text.Shadow.OffsetY *= f
+	config.Text.Shadow.Blur *= f
+	config.Logo.Margin *= f
+	return config
+}
+
+// scaled returns p with a pixel position multiplied by f.
+func (p *Position) scaled(f int) *Position {
+	if p == nil || !p.Pixels {
+		return p
+	}
+	s := *p
+	s.Value *= float64(f)
+	return &s
+}
@@ -0,0 +1,117 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// context returns the context of the running merge. Canceling it kills
+// any ffmpeg or ffprobe process started from config.
+func (config Config) context() context.Context {
+	if config.ctx == nil {
+		return context.Background()
+	}
+	return config.ctx
+}
+
+// Runner runs the ffmpeg and ffprobe commands a merge builds. The command's
+// arguments, Stdout, and Stderr are set up before Run is called; a Runner
+// that doesn't execute it must still write any output the caller expects,
+// such as probe results, to cmd.Stdout. Substituting one lets callers
+// record or fake the commands without encoding anything.
+type Runner interface {
+	Run(cmd *exec.Cmd) error
+}
+
+// run executes cmd with config.Runner, or directly when none is set. A
+// failure is tagged ErrRemote for the AWS CLI and ErrFFmpeg otherwise.
+func (config Config) run(cmd *exec.Cmd) error {
+	if cmd.Args[0] == config.ffmpegPath() {
+		defer config.Metrics.ffmpegRun(time.Now())
+	}
+	kind := ErrFFmpeg
+	if cmd.Args[0] == config.awsPath() {
+		kind = ErrRemote
+	}
+	if config.Runner != nil {
+		return withKind(kind, config.Runner.Run(cmd))
+	}
+	return withKind(kind, cmd.Run())
+}
+
+// retry calls attempt until it succeeds, fails for a reason other than
+// the command exiting nonzero (such as a missing binary), or has been
+// retried config.Retry.Count times. The delay between attempts starts at
+// config.Retry.Backoff seconds and doubles each time.
+func (config Config) retry(what string, attempt func() error) error {
+	delay := time.Duration(config.Retry.Backoff * float64(time.Second))
+	for n := 1; ; n++ {
+		err := attempt()
+		var exitErr *exec.ExitError
+		if err == nil || n > config.Retry.Count || !errors.As(err, &exitErr) || config.context().Err() != nil {
+			return err
+		}
+		config.logger().Infof("%s failed (%v), retrying in %s (%d/%d)", what, err, delay, n, config.Retry.Count)
+		select {
+		case <-time.After(delay):
+		case <-config.context().Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (config Config) ffmpegCommand(args ...string) *exec.Cmd {
+	return exec.CommandContext(config.context(), config.ffmpegPath(), args...)
+}
+
+func (config Config) ffprobeCommand(args ...string) *exec.Cmd {
+	return exec.CommandContext(config.context(), config.ffprobePath(), args...)
+}
+
+func (config Config) ffmpegPath() string {
+	if config.FFmpegPath != "" {
+		return config.FFmpegPath
+	}
+	return "ffmpeg"
+}
+
+func (config Config) ffprobePath() string {
+	if config.FFprobePath != "" {
+		return config.FFprobePath
+	}
+	return "ffprobe"
+}
+
+// lookPath checks that tool can be run. A custom Runner decides for
+// itself how commands run, so with one set every tool is assumed to be
+// there.
+func (config Config) lookPath(tool string) error {
+	if config.Runner != nil {
+		return nil
+	}
+	_, err := exec.LookPath(tool)
+	return err
+}
+
+// CheckTools verifies that ffmpeg and ffprobe, and the AWS CLI when a
+// source is on S3, can be found, so a missing install is reported before
+// any work is done. With a custom Runner set, it checks nothing.
+func CheckTools(config Config) error {
+	for _, tool := range []string{config.ffmpegPath(), config.ffprobePath()} {
+		if err := config.lookPath(tool); err != nil {
+			return withKind(ErrFFmpeg, fmt.Errorf("%s not found (%w); install ffmpeg from https://ffmpeg.org/download.html "+
+				"and make sure it is on your PATH, or set \"ffmpegPath\"/\"ffprobePath\" in the config", tool, err))
+		}
+	}
+	if config.usesS3() {
+		if err := config.lookPath(config.awsPath()); err != nil {
+			return withKind(ErrRemote, fmt.Errorf("%s not found (%w); s3:// sources need the AWS CLI from https://aws.amazon.com/cli/ "+
+				"on your PATH, or set \"awsPath\" in the config", config.awsPath(), err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package merger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTimestamp parses [[hh:]mm:]ss[.frac] into seconds.
+func parseTimestamp(ts string) (float64, error) {
+	parts := strings.Split(ts, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time '%s'", ts)
+	}
+	var seconds float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time '%s'", ts)
+		}
+		// Minutes and hours must be whole; only the seconds can be fractional.
+		if i < len(parts)-1 && v != float64(int(v)) {
+			return 0, fmt.Errorf("invalid time '%s'", ts)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// trimRange returns the entry's start and end in seconds. An unset end is
+// returned as -1.
+func trimRange(entry SourceEntry) (start, end float64, err error) {
+	end = -1
+	if entry.Start != "" {
+		if start, err = parseTimestamp(entry.Start); err != nil {
+			return 0, 0, err
+		}
+	}
+	if entry.End != "" {
+		if end, err = parseTimestamp(entry.End); err != nil {
+			return 0, 0, err
+		}
+		if end <= start {
+			return 0, 0, fmt.Errorf("end %s is not after start %s", entry.End, entry.Start)
+		}
+	}
+	return start, end, nil
+}
+
+// trimVideo re-encodes the entry's range of its video to output in the
+// intermediate format, so it can be concatenated with the cards.
+func trimVideo(config Config, entry SourceEntry, output string) error {
+	start, end, err := trimRange(entry)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"-y"}, config.hwaccelArgs()...)
+	if start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start, 'f', -1, 64))
+	}
+	args = append(args, "-i", entry.Path)
+	if end >= 0 {
+		args = append(args, "-t", strconv.FormatFloat(end-start, 'f', -1, 64))
+	}
+	args = append(args, config.intermediateEncoderArgs()...)
+	cmd := config.ffmpegCommand(append(args, output)...)
+	cmd.Stdout = config.logger().FFmpegOutput()
+	cmd.Stderr = config.logger().FFmpegOutput()
+	return config.run(cmd)
+}
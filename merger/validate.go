@@ -0,0 +1,385 @@
+package merger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateConfig checks config for values that would make a merge fail or
+// silently misbehave. It returns every problem found, not just the first.
+func ValidateConfig(config Config) []error {
+	var errs []error
+
+	// Templated outputs may name a different directory per run; checking
+	// one expansion catches a bad parent.
+	outputDir := filepath.Dir(resolveOutput(config, 0))
+	if err := checkWritable(outputDir); err != nil {
+		errs = append(errs, fmt.Errorf("output directory '%s' is not writable: %w", outputDir, err))
+	}
+	if dir := config.Dest.IntermediateTextDir; dir != "" {
+		if err := checkWritable(dir); err != nil {
+			errs = append(errs, fmt.Errorf("dest.intermediateTextDir '%s' is not writable: %w", dir, err))
+		}
+	} else if config.CacheTransitions {
+		errs = append(errs, errors.New("cacheTransitions needs dest.intermediateTextDir to keep the cache between runs"))
+	}
+
+	if config.Font.Path != "" {
+		if _, err := os.Stat(config.Font.Path); err != nil {
+			errs = append(errs, fmt.Errorf("font.path '%s' is not readable: %w", config.Font.Path, err))
+		}
+	}
+	for i, path := range config.Font.Fallbacks {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("font.fallbacks[%d] '%s' is not readable: %w", i, path, err))
+		}
+	}
+	if config.Font.Size <= 0 {
+		errs = append(errs, fmt.Errorf("font.size must be > 0, got %v", config.Font.Size))
+	}
+
+	switch config.Frame.Auto {
+	case "":
+		if config.Frame.Width <= 0 {
+			errs = append(errs, fmt.Errorf("frame.width must be > 0, got %d", config.Frame.Width))
+		}
+		if config.Frame.Height <= 0 {
+			errs = append(errs, fmt.Errorf("frame.height must be > 0, got %d", config.Frame.Height))
+		}
+		if config.Frame.Rate <= 0 {
+			errs = append(errs, fmt.Errorf("frame.rate must be > 0, got %d", config.Frame.Rate))
+		}
+		if config.Text.Margin < 0 || 2*config.Text.Margin >= config.Frame.Width || 2*config.Text.Margin >= config.Frame.Height {
+			errs = append(errs, fmt.Errorf("text.margin %d doesn't leave room for text in a %dx%d frame",
+				config.Text.Margin, config.Frame.Width, config.Frame.Height))
+		}
+	case FrameAutoFirst, FrameAutoLargest:
+		// The frame isn't known until the sources are probed.
+		if config.Text.Margin < 0 {
+			errs = append(errs, fmt.Errorf("text.margin must be >= 0, got %d", config.Text.Margin))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("frame.auto must be %q or %q, got %q", FrameAutoFirst, FrameAutoLargest, config.Frame.Auto))
+	}
+
+	if _, err := config.Scan.dateRange(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if s := config.Frame.Supersample; s < 0 || s > 8 {
+		errs = append(errs, fmt.Errorf("frame.supersample must be between 0 and 8, got %d", s))
+	}
+
+	switch config.FrameFormat {
+	case FrameFormatPNG:
+	case FrameFormatJPEG:
+		if config.FrameQuality < 1 || config.FrameQuality > 100 {
+			errs = append(errs, fmt.Errorf("frameQuality must be between 1 and 100, got %d", config.FrameQuality))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("frameFormat must be %q or %q, got %q", FrameFormatPNG, FrameFormatJPEG, config.FrameFormat))
+	}
+
+	if config.Text.Duration < 0 {
+		errs = append(errs, fmt.Errorf("text.duration must be >= 0, got %d", config.Text.Duration))
+	}
+	for key, d := range config.Text.Durations {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("text.durations[%q] must be >= 0, got %d", key, d))
+		}
+	}
+	if config.Intro.Text != "" && config.Intro.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("intro.duration must be > 0, got %d", config.Intro.Duration))
+	}
+	if config.Outro.Text != "" && config.Outro.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("outro.duration must be > 0, got %d", config.Outro.Duration))
+	}
+	if config.PreviewBackground.Enabled && (config.PreviewBackground.Dim < 0 || config.PreviewBackground.Dim > 1) {
+		errs = append(errs, fmt.Errorf("previewBackground.dim must be between 0 and 1, got %g", config.PreviewBackground.Dim))
+	}
+	if config.TOC.Enabled {
+		if config.TOC.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("tableOfContents.duration must be > 0, got %d", config.TOC.Duration))
+		}
+		if config.TOC.PerPage <= 0 {
+			errs = append(errs, fmt.Errorf("tableOfContents.perPage must be > 0, got %d", config.TOC.PerPage))
+		}
+	}
+	if config.Text.MaxWidthFraction <= 0 || config.Text.MaxWidthFraction > 1 {
+		errs = append(errs, fmt.Errorf("text.maxWidthFraction must be in (0, 1], got %v", config.Text.MaxWidthFraction))
+	}
+	switch config.Text.HAlign {
+	case "", AlignLeft, AlignCenter, AlignRight:
+	default:
+		errs = append(errs, fmt.Errorf("text.hAlign must be left, center, or right, got %q", config.Text.HAlign))
+	}
+	switch config.Text.VAlign {
+	case "", AlignTop, AlignMiddle, AlignBottom:
+	default:
+		errs = append(errs, fmt.Errorf("text.vAlign must be top, middle, or bottom, got %q", config.Text.VAlign))
+	}
+	for _, p := range []struct {
+		key  string
+		pos  *Position
+		size int
+	}{
+		{"text.textX", config.Text.TextX, config.Frame.Width},
+		{"text.textY", config.Text.TextY, config.Frame.Height},
+	} {
+		switch {
+		case p.pos == nil:
+		case p.pos.Pixels && p.pos.Value < 0:
+			errs = append(errs, fmt.Errorf("%s must be >= 0px, got %vpx", p.key, p.pos.Value))
+		case p.pos.Pixels && config.Frame.Auto == "" && p.pos.Value > float64(p.size):
+			errs = append(errs, fmt.Errorf("%s must be within the frame (0 to %dpx), got %vpx", p.key, p.size, p.pos.Value))
+		case !p.pos.Pixels && (p.pos.Value < 0 || p.pos.Value > 1):
+			errs = append(errs, fmt.Errorf("%s must be a fraction from 0 to 1, got %v", p.key, p.pos.Value))
+		}
+	}
+	if _, err := parseColor(config.Text.Color); err != nil {
+		errs = append(errs, fmt.Errorf("text.color: %w", err))
+	}
+	if _, err := parseColor(config.Text.Background); err != nil {
+		errs = append(errs, fmt.Errorf("text.background: %w", err))
+	}
+
+	switch config.Text.TextAnimation {
+	case "", AnimationNone, AnimationSlideLeft, AnimationSlideUp, AnimationZoom:
+	default:
+		errs = append(errs, fmt.Errorf("text.textAnimation must be %q, %q, %q, or %q, got %q",
+			AnimationNone, AnimationSlideLeft, AnimationSlideUp, AnimationZoom, config.Text.TextAnimation))
+	}
+	for i, style := range config.RandomStyles {
+		errs = append(errs, validateCardStyle(fmt.Sprintf("randomStyles[%d]", i), style)...)
+	}
+	for _, name := range config.styleNames() {
+		errs = append(errs, validateCardStyle(fmt.Sprintf("styles.%s", name), config.Styles[name])...)
+	}
+	for key, name := range config.TransitionStyles {
+		if _, ok := config.Styles[name]; !ok {
+			errs = append(errs, fmt.Errorf("transitionStyles.%s: no style named %q in styles", key, name))
+		}
+	}
+	if config.Text.Stroke.Color != "" {
+		if _, err := parseColor(config.Text.Stroke.Color); err != nil {
+			errs = append(errs, fmt.Errorf("text.stroke.color: %w", err))
+		}
+		if config.Text.Stroke.Width <= 0 {
+			errs = append(errs, fmt.Errorf("text.stroke.width must be > 0, got %d", config.Text.Stroke.Width))
+		}
+	}
+	if config.Text.Shadow.Color != "" {
+		if _, err := parseColor(config.Text.Shadow.Color); err != nil {
+			errs = append(errs, fmt.Errorf("text.shadow.color: %w", err))
+		}
+		if config.Text.Shadow.Blur < 0 {
+			errs = append(errs, fmt.Errorf("text.shadow.blur must be >= 0, got %d", config.Text.Shadow.Blur))
+		}
+	}
+
+	if config.Text.BackgroundImage != "" {
+		if _, err := os.Stat(config.Text.BackgroundImage); err != nil {
+			errs = append(errs, fmt.Errorf("text.backgroundImage '%s' is not readable: %w", config.Text.BackgroundImage, err))
+		}
+	} else if config.Text.Gradient.From != "" {
+		if _, err := newGradient(config.Text.Gradient, 1, 1); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch config.ConcatMode {
+	case "", ConcatCopy, ConcatReencode, ConcatAuto, ConcatFast:
+	default:
+		errs = append(errs, fmt.Errorf("concatMode must be %q, %q, %q, or %q, got %q", ConcatCopy, ConcatReencode, ConcatAuto, ConcatFast, config.ConcatMode))
+	}
+
+	if name := config.HardwareEncoder; name != "" {
+		if _, ok := hardwareEncoders[name]; !ok {
+			errs = append(errs, fmt.Errorf("hardwareEncoder must be one of %s, got %q", strings.Join(hardwareEncoderNames(), ", "), name))
+		} else if config.intermediateFormat() != FormatMP4 {
+			errs = append(errs, fmt.Errorf("hardwareEncoder %s encodes H.264, which %s output can't use", name, config.outputFormat()))
+		}
+	}
+
+	switch config.DedupeBy {
+	case "", DedupeByPath, DedupeByContent:
+	default:
+		errs = append(errs, fmt.Errorf("dedupeBy must be %q or %q, got %q", DedupeByPath, DedupeByContent, config.DedupeBy))
+	}
+	if config.OutputRate < 0 {
+		errs = append(errs, fmt.Errorf("outputRate must be > 0, or 0 to use frame.rate, got %d", config.OutputRate))
+	}
+	if config.Threads < 0 {
+		errs = append(errs, fmt.Errorf("threads must be >= 0, got %d", config.Threads))
+	}
+
+	switch config.ConcatMethod {
+	case "", ConcatMethodDemuxer, ConcatMethodFilter:
+	default:
+		errs = append(errs, fmt.Errorf("concatMethod must be %q or %q, got %q", ConcatMethodDemuxer, ConcatMethodFilter, config.ConcatMethod))
+	}
+
+	switch config.Fit {
+	case "", FitStretch, FitCrop:
+	case FitPad:
+		if _, err := parseColor(config.PadColor); err != nil {
+			errs = append(errs, fmt.Errorf("padColor: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("fit must be %q, %q, or %q, got %q", FitStretch, FitPad, FitCrop, config.Fit))
+	}
+
+	if config.MaxCardFrames < 0 {
+		errs = append(errs, fmt.Errorf("maxCardFrames must be >= 0, got %d", config.MaxCardFrames))
+	}
+	if config.EncodeWorkers < 0 {
+		errs = append(errs, fmt.Errorf("encodeWorkers must be >= 0, got %d", config.EncodeWorkers))
+	}
+	if config.Retry.Count < 0 {
+		errs = append(errs, fmt.Errorf("retry.count must be >= 0, got %d", config.Retry.Count))
+	}
+	if config.Retry.Backoff < 0 {
+		errs = append(errs, fmt.Errorf("retry.backoff must be >= 0, got %v", config.Retry.Backoff))
+	}
+
+	if config.Poster.Enabled && config.Poster.At != "" {
+		if _, err := posterTime(config.Poster.At, 0); err != nil {
+			errs = append(errs, fmt.Errorf("poster.at: %w", err))
+		}
+	}
+
+	if config.Chapters && config.outputFormat() == FormatGIF {
+		errs = append(errs, errors.New("gif output can't carry chapters"))
+	}
+	if config.Subtitles.Enabled {
+		if config.Subtitles.Mode != SubtitlesSoft {
+			errs = append(errs, fmt.Errorf("subtitles.mode must be %q, got %q", SubtitlesSoft, config.Subtitles.Mode))
+		}
+		if config.Subtitles.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("subtitles.duration must be > 0, got %v", config.Subtitles.Duration))
+		}
+		if config.outputFormat() == FormatGIF {
+			errs = append(errs, errors.New("gif output can't carry subtitles"))
+		}
+	}
+	if config.MetadataSource != "" && !config.PreserveMetadata {
+		errs = append(errs, errors.New("metadataSource is set but preserveMetadata is off"))
+	}
+
+	errs = append(errs, validateFormat(config)...)
+	errs = append(errs, validateArgs("ffmpegArgs", config.FFmpegArgs)...)
+	errs = append(errs, validateArgs("concatArgs", config.ConcatArgs)...)
+	errs = append(errs, validateHook("preProcess", config.PreProcess)...)
+	errs = append(errs, validateHook("postProcess", config.PostProcess)...)
+	errs = append(errs, validateMusic(config)...)
+	errs = append(errs, validateMaxDuration(config)...)
+	errs = append(errs, validateBumper(config)...)
+
+	switch config.TransitionType {
+	case "", TransitionCard:
+	case TransitionXfade:
+		if config.Xfade.Style == "" {
+			errs = append(errs, errors.New("xfade.style must be set"))
+		}
+		if config.Xfade.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("xfade.duration must be > 0, got %v", config.Xfade.Duration))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("transitionType must be %q or %q, got %q", TransitionCard, TransitionXfade, config.TransitionType))
+	}
+
+	if config.Logo.Path != "" {
+		if _, err := os.Stat(config.Logo.Path); err != nil {
+			errs = append(errs, fmt.Errorf("logo.path '%s' is not readable: %w", config.Logo.Path, err))
+		}
+		if _, ok := anchorFractions[config.Logo.Position]; !ok {
+			errs = append(errs, fmt.Errorf("logo.position '%s' is not a valid anchor", config.Logo.Position))
+		}
+		if config.Logo.Opacity < 0 || config.Logo.Opacity > 1 {
+			errs = append(errs, fmt.Errorf("logo.opacity must be in [0, 1], got %v", config.Logo.Opacity))
+		}
+	}
+
+	for i, source := range config.Source {
+		if source.Path == "" {
+			errs = append(errs, fmt.Errorf("source[%d].path must be set", i))
+		}
+		if _, ok := config.Styles[source.Style]; source.Style != "" && !ok {
+			errs = append(errs, fmt.Errorf("source[%d].style: no style named %q in styles", i, source.Style))
+		}
+		if _, _, err := trimRange(source); err != nil {
+			errs = append(errs, fmt.Errorf("source[%d]: %w", i, err))
+		}
+	}
+	// Only whether the sources resolve matters here; deduping is left to
+	// the merge, so content hashes aren't computed just to validate.
+	noDedupe := config
+	noDedupe.DedupeConsecutive = false
+	if _, err := ResolveVideos(noDedupe); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// checkWritable reports whether files can be created in dir, or in its
+// nearest existing ancestor when dir doesn't exist yet.
+func checkWritable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("'%s' is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".write_check_*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// validateCardStyle checks a random or named card style, naming it by
+// prefix in errors.
+func validateCardStyle(prefix string, style CardStyle) []error {
+	var errs []error
+	if style.Background != "" {
+		if _, err := parseColor(style.Background); err != nil {
+			errs = append(errs, fmt.Errorf("%s.background: %w", prefix, err))
+		}
+	}
+	if style.Color != "" {
+		if _, err := parseColor(style.Color); err != nil {
+			errs = append(errs, fmt.Errorf("%s.color: %w", prefix, err))
+		}
+	}
+	switch style.TextAnimation {
+	case "", AnimationNone, AnimationSlideLeft, AnimationSlideUp, AnimationZoom:
+	default:
+		errs = append(errs, fmt.Errorf("%s.textAnimation must be %q, %q, %q, or %q, got %q",
+			prefix, AnimationNone, AnimationSlideLeft, AnimationSlideUp, AnimationZoom, style.TextAnimation))
+	}
+	if style.FontPath != "" {
+		if _, err := os.Stat(style.FontPath); err != nil {
+			errs = append(errs, fmt.Errorf("%s.fontPath '%s' is not readable: %w", prefix, style.FontPath, err))
+		}
+	}
+	if style.FontSize < 0 {
+		errs = append(errs, fmt.Errorf("%s.fontSize must be > 0, got %g", prefix, style.FontSize))
+	}
+	return errs
+}
@@ -0,0 +1,98 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch merges once, then watches config.SourceDir and merges again
+// whenever the scanned video list changes. Bursts of events are coalesced
+// until debounce has passed without another one. Cards are cached as
+// with CacheTransitions, so a rebuild only renders the cards that
+// changed. onMerge is called with the outcome of every merge. Watch only
+// returns if the watcher fails or ctx is canceled, which also stops a
+// merge in progress.
+func Watch(ctx context.Context, config Config, debounce time.Duration, onMerge func(Result, error)) error {
+	if len(config.Source) > 0 {
+		return errors.New("watching requires scanning sourceDir; remove the explicit source list")
+	}
+	config = config.WithDefaults()
+	config.CacheTransitions = true
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error starting watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watchDirs(watcher, config.sourceDir(), config.Scan.Recursive); err != nil {
+		return err
+	}
+
+	videos, _ := ResolveVideos(config)
+	onMerge(MergeContext(ctx, config))
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// New subdirectories need watching too when scanning recursively.
+			if config.Scan.Recursive && event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watchDirs(watcher, event.Name, true)
+				}
+			}
+			timer = time.After(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("error watching '%s': %w", config.sourceDir(), err)
+		case <-timer:
+			timer = nil
+			current, err := ResolveVideos(config)
+			if err != nil {
+				onMerge(Result{}, err)
+				continue
+			}
+			// Events for non-video files or in-place edits don't change
+			// the merge plan, so there's nothing to rebuild.
+			if slices.Equal(current, videos) {
+				continue
+			}
+			videos = current
+			config.logger().Infof("[%s] Source videos changed, rebuilding", time.Now().Format(time.DateTime))
+			onMerge(MergeContext(ctx, config))
+		}
+	}
+}
+
+func watchDirs(watcher *fsnotify.Watcher, root string, recursive bool) error {
+	if !recursive {
+		return watcher.Add(root)
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				return fmt.Errorf("error watching '%s': %w", path, err)
+			}
+		}
+		return nil
+	})
+}
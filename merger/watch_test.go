@@ -0,0 +1,64 @@
+package merger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchReusesUnchangedCards(t *testing.T) {
+	config := testConfig(t, &fakeRunner{}, 2)
+	// Scan a dir of just the clips, away from the output.
+	config.SourceDir = t.TempDir()
+	for _, source := range config.Source {
+		if err := os.Rename(source.Path, filepath.Join(config.SourceDir, filepath.Base(source.Path))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Source = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type outcome struct {
+		result Result
+		err    error
+	}
+	merges := make(chan outcome, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, config, 10*time.Millisecond, func(result Result, err error) {
+			merges <- outcome{result, err}
+		})
+	}()
+
+	next := func() Result {
+		t.Helper()
+		select {
+		case m := <-merges:
+			if m.err != nil {
+				t.Fatal(m.err)
+			}
+			return m.result
+		case err := <-done:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a merge")
+		}
+		return Result{}
+	}
+	if first := next(); first.Frames != 1 {
+		t.Fatalf("first merge drew %d frames, want the 1 card", first.Frames)
+	}
+	if err := os.WriteFile(filepath.Join(config.SourceDir, "clip3.mp4"), []byte("fake"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The card before clip2 is unchanged, so only the one before clip3
+	// is drawn.
+	if second := next(); second.Transitions != 2 || second.Frames != 1 {
+		t.Errorf("rebuild has %d transitions and drew %d frames, want 2 and 1", second.Transitions, second.Frames)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,93 @@
+package merger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// xfadeVideos crossfades each segment into the next with ffmpeg's xfade
+// and acrossfade filters. Unlike the concat demuxer this always
+// re-encodes, and every segment is scaled to the frame config first
+// because xfade requires matching resolution, frame rate, and pixel
+// format. Every segment must have an audio stream. Long segment lists are
+// crossfaded in parts, which are then crossfaded together.
+func xfadeVideos(config Config, runDir string, segments []string, output string) error {
+	return xfadeInto(config, runDir, segments, output, config.outputFormat())
+}
+
+// xfadeInto crossfades segments into output encoded as format. Parts are
+// encoded in the intermediate format, so they keep their audio for the
+// next level even when the output is a GIF.
+func xfadeInto(config Config, runDir string, segments []string, output, format string) error {
+	if chunks := chunkSegments(segments); len(chunks) > 1 {
+		// Each level has fewer segments than the one before, so naming
+		// parts by the count keeps them from overwriting each other.
+		name := fmt.Sprintf("xfade_%d", len(segments))
+		parts, err := mergeChunks(config, runDir, name, chunks, func(chunk []string, part string) error {
+			return xfadeInto(config, runDir, chunk, part, config.intermediateFormat())
+		})
+		if err != nil {
+			return err
+		}
+		return xfadeInto(config, runDir, parts, output, format)
+	}
+
+	audio := resolveAudio(config, segments)
+	d := config.Xfade.Duration
+
+	durations := make([]float64, len(segments))
+	for k, segment := range segments {
+		duration, err := probeDuration(config, segment)
+		if err != nil {
+			return err
+		}
+		if len(segments) > 1 && duration <= d {
+			return fmt.Errorf("'%s' is %.2fs, too short for a %.2fs crossfade", segment, duration, d)
+		}
+		durations[k] = duration
+	}
+
+	args := []string{"-y", "-progress", "pipe:1"}
+	for _, segment := range segments {
+		args = append(args, "-i", segment)
+	}
+
+	var graph []string
+	for k := range segments {
+		graph = append(graph,
+			fmt.Sprintf("[%d:v]%s,fps=%d,format=yuv420p[v%d]", k,
+				fitFilter(config), config.outputRate(), k),
+			fmt.Sprintf("[%d:a]aformat=sample_rates=%d:channel_layouts=%s[a%d]", k,
+				audio.SampleRate, audio.ChannelLayout, k))
+	}
+
+	// Each crossfade starts d seconds before the end of the output so far.
+	vLabel, aLabel := "v0", "a0"
+	offset := 0.0
+	for k := 1; k < len(segments); k++ {
+		offset += durations[k-1] - d
+		graph = append(graph,
+			fmt.Sprintf("[%s][v%d]xfade=transition=%s:duration=%.3f:offset=%.3f[xv%d]",
+				vLabel, k, config.Xfade.Style, d, offset, k),
+			fmt.Sprintf("[%s][a%d]acrossfade=d=%.3f[xa%d]", aLabel, k, d, k))
+		vLabel, aLabel = fmt.Sprintf("xv%d", k), fmt.Sprintf("xa%d", k)
+	}
+
+	args = append(args, "-filter_complex", strings.Join(graph, ";"),
+		"-map", "["+vLabel+"]")
+	if config.audioCodec(format) != "" {
+		args = append(args, "-map", "["+aLabel+"]")
+	}
+	args = append(args, config.encoderArgs(format)...)
+	args = append(args, config.outputArgs(format)...)
+	args = append(args, output)
+
+	var total float64
+	for _, duration := range durations {
+		total += duration
+	}
+	total -= float64(len(segments)-1) * d
+	return config.retry("Crossfade", func() error {
+		return runWithProgress(config, args, total)
+	})
+}
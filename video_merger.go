@@ -1,233 +1,480 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"image/color"
-	"io/ioutil"
+	"io"
+	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"sort"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/fogleman/gg"
-)
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
-type Config struct {
-	Dest   Destination `json:"dest"`
-	Source []string    `json:"source"`
-	Font   FontConfig  `json:"font"`
-	Frame  FrameConfig `json:"frame"`
-	Text   TextConfig  `json:"text"`
-}
+	"video_merger/merger"
+)
 
-type Destination struct {
-	Output              string `json:"output"`
-	IntermediateTextDir string `json:"intermediateTextDir"`
+const defaultConfigFile = "config.json"
+
+const progressBarWidth = 30
+
+// watchDebounce is how long -watch waits for the source directory to settle
+// before rebuilding.
+const watchDebounce = 2 * time.Second
+
+// renderProgress reports transition builds and draws a progress bar for
+// the final merge on stderr.
+func renderProgress(p merger.Progress) {
+	switch p.Stage {
+	case merger.StageTransition:
+		fmt.Fprintf(os.Stderr, "Building transition %d/%d\n", p.Current, p.Total)
+	case merger.StageMerge:
+		filled := int(p.Percent / 100 * progressBarWidth)
+		bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled)
+		fmt.Fprintf(os.Stderr, "\r[%s] %5.1f%%", bar, p.Percent)
+		if p.Percent >= 100 {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
 }
 
-type FontConfig struct {
-	Path string  `json:"path"`
-	Size float64 `json:"size"`
+func listVideos(config merger.Config) error {
+	videos, err := merger.ResolveVideos(config)
+	if err != nil {
+		return err
+	}
+	for i, video := range videos {
+		if merger.IsRemote(video) {
+			fmt.Printf("%3d  %s\n", i, video)
+			continue
+		}
+		abs, err := filepath.Abs(video)
+		if err != nil {
+			return fmt.Errorf("error resolving '%s': %w", video, err)
+		}
+		fmt.Printf("%3d  %s\n", i, abs)
+	}
+	return nil
 }
 
-type FrameConfig struct {
-	Width  int `json:"width"`
-	Height int `json:"height"`
-	Rate   int `json:"rate"`
-}
+// readFileList reads newline-separated video paths from path, or stdin
+// when path is "-". Blank lines are skipped; every listed local file must
+// exist, while URLs are checked when the merge starts.
+func readFileList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file list '%s': %w", path, err)
+		}
+		defer f.Close()
+		r = f
+	}
 
-type TextConfig struct {
-	Color      string `json:"color"`
-	Background string `json:"background"`
-	Duration   int    `json:"duration"`
+	var videos, missing []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		video := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(video) == "" {
+			continue
+		}
+		if _, err := os.Stat(video); err != nil && !merger.IsRemote(video) {
+			missing = append(missing, video)
+		}
+		videos = append(videos, video)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file list '%s': %w", path, err)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("file list '%s' names missing videos: %s", path, strings.Join(missing, ", "))
+	}
+	if len(videos) == 0 {
+		return nil, fmt.Errorf("file list '%s' is empty", path)
+	}
+	return videos, nil
 }
 
-func hexToRGBA(hex string) color.Color {
-	var r, g, b int
-	fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b)
-	return color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+// options holds the parsed command-line flags.
+type options struct {
+	configFile  string
+	dryRun      bool
+	previewDir  string
+	list        bool
+	verbose     bool
+	quiet       bool
+	json        bool
+	watch       bool
+	files       string
+	keep        bool
+	since       string
+	until       string
+	reverse     bool
+	threads     int
+	seed        int64
+	seedSet     bool
+	force       bool
+	logFile     string
+	maxDuration float64
+	metricsAddr string
+	metricsFile string
 }
 
-func hexToRGBA2(hex string) color.Color {
-	hex = strings.TrimPrefix(hex, "#")
-
-	var r, g, b uint8
-	if len(hex) == 6 {
-		var ri, gi, bi int
-		_, err := fmt.Sscanf(hex, "%02x%02x%02x", &ri, &gi, &bi)
-		if err != nil {
-			return color.RGBA{0, 0, 0, 255}
+func parseFlags() options {
+	var opts options
+	flag.StringVar(&opts.configFile, "config", "", "path to the config file; .yaml, .yml, and .toml are read as YAML or TOML, anything else as JSON (default \""+defaultConfigFile+"\")")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "print the merge plan without generating frames or running ffmpeg")
+	flag.StringVar(&opts.previewDir, "preview-cards", "", "render the last frame of each card and a contact sheet into this directory, and exit without merging")
+	flag.BoolVar(&opts.list, "list", false, "print the discovered videos in merge order and exit")
+	flag.BoolVar(&opts.verbose, "verbose", false, "show full ffmpeg output")
+	flag.BoolVar(&opts.quiet, "quiet", false, "only print the final result and errors")
+	flag.BoolVar(&opts.json, "json", false, "print a JSON summary (or error) to stdout; logs go to stderr")
+	flag.BoolVar(&opts.watch, "watch", false, "re-merge whenever videos are added to or removed from the source directory")
+	flag.StringVar(&opts.files, "files", "", "read the videos to merge, in order, from this file (\"-\" for stdin) instead of the config; only -reverse reorders them")
+	flag.BoolVar(&opts.keep, "keep-intermediates", false, "keep the generated frames, clips, and filelist and print where they are")
+	flag.StringVar(&opts.since, "since", "", "only merge scanned videos modified at or after this time (RFC 3339 or YYYY-MM-DD)")
+	flag.StringVar(&opts.until, "until", "", "only merge scanned videos modified before this time, or on or before this date (RFC 3339 or YYYY-MM-DD)")
+	flag.BoolVar(&opts.reverse, "reverse", false, "merge the videos in reverse order, after sorting")
+	flag.IntVar(&opts.threads, "threads", 0, "cap the threads each ffmpeg encode uses (default: ffmpeg decides, or \"threads\" in the config)")
+	flag.Int64Var(&opts.seed, "seed", 0, "seed for picking each card's entry in \"randomStyles\" (default: \"seed\" in the config)")
+	flag.BoolVar(&opts.force, "force", false, "merge even if a card has more frames than \"maxCardFrames\", without asking")
+	flag.StringVar(&opts.logFile, "log", "", "also append a timestamped record of the merge to this file (default: \"logFile\" in the config)")
+	flag.Float64Var(&opts.maxDuration, "max-duration", 0, "cut the output to this many seconds (default: \"maxDuration\" in the config)")
+	flag.StringVar(&opts.metricsAddr, "metrics-addr", "", "serve Prometheus metrics at http://ADDR/metrics while running, e.g. \":9100\"")
+	flag.StringVar(&opts.metricsFile, "metrics-file", "", "write Prometheus metrics to this file after each merge")
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			opts.seedSet = true
 		}
-		r, g, b = uint8(ri), uint8(gi), uint8(bi)
-	} else {
-		return color.RGBA{0, 0, 0, 255}
+	})
+
+	if opts.configFile == "" {
+		opts.configFile = defaultConfigFile
 	}
+	return opts
+}
 
-	return color.RGBA{r, g, b, 255}
+// jsonSummary is the -json output of a successful merge.
+type jsonSummary struct {
+	Output         string  `json:"output"`
+	Poster         string  `json:"poster,omitempty"`
+	Clips          int     `json:"clips"`
+	Transitions    int     `json:"transitions"`
+	Frames         int     `json:"frames"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	OutputBytes    int64   `json:"outputBytes"`
 }
 
-func getVideoFiles(sourceDir string) ([]string, error) {
-	var videos []string
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// interruptContext returns a context canceled by the first SIGINT or
+// SIGTERM, so the merge can stop ffmpeg and clean up. A second signal
+// exits immediately.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Print("Interrupted, stopping and cleaning up (press Ctrl-C again to force quit)")
+		cancel()
+		<-signals
+		os.Exit(130)
+	}()
+	return ctx
+}
 
-	allowedExt := map[string]bool{
-		".mp4": true,
-		".mov": true,
-		".avi": true,
-		".mkv": true,
+// interactive reports whether stdin is a terminal someone can answer a
+// prompt on. The null device is a character device too, but nobody is
+// there to answer.
+func interactive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
 	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
 
-	err := filepath.WalkDir(sourceDir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			ext := strings.ToLower(filepath.Ext(path))
-			if allowedExt[ext] {
-				videos = append(videos, filepath.Clean(path))
-			}
-		}
-		return nil
-	})
+// confirm asks question on stderr and reports whether the answer is yes.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
 
+// serveMetrics serves the metrics in reg at /metrics on addr until the
+// returned stop is called. The listener is opened up front so a taken
+// port fails the run.
+func serveMetrics(addr string, reg *prometheus.Registry) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("error walking directory: %w", err)
+		return nil, fmt.Errorf("error serving metrics on '%s': %w", addr, err)
 	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return func() { srv.Close() }, nil
+}
 
-	sort.Strings(videos)
-	return videos, nil
+// writeMetrics writes the metrics in reg to path in the text exposition
+// format, replacing the file atomically so a scraper never reads half.
+func writeMetrics(path string, reg *prometheus.Registry) {
+	if err := prometheus.WriteToTextfile(path, reg); err != nil {
+		log.Printf("Could not write metrics to '%s': %v", path, err)
+	}
+}
+
+// errorKind names the kind of a failed run for -json output, so scripts
+// can tell an install problem from a bad config without parsing messages.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, merger.ErrConfigInvalid):
+		return "config"
+	case errors.Is(err, merger.ErrNoSources):
+		return "sources"
+	case errors.Is(err, merger.ErrFontLoad):
+		return "font"
+	case errors.Is(err, merger.ErrFFmpeg):
+		return "ffmpeg"
+	case errors.Is(err, merger.ErrRemote):
+		return "remote"
+	case errors.Is(err, merger.ErrTooManyFrames):
+		return "frames"
+	}
+	return "other"
 }
 
 func main() {
-	// --- Load Config ---
-	configFile := "config.json"
-	configData, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		fmt.Printf("Error reading config file: %v\n", err)
-		return
+	log.SetFlags(0)
+	opts := parseFlags()
+	if err := run(interruptContext(), opts); err != nil {
+		if opts.json {
+			printJSON(map[string]string{"error": err.Error(), "kind": errorKind(err)})
+		} else {
+			log.Print(err)
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && !opts.verbose {
+				log.Print("Run with -verbose to see ffmpeg's output.")
+			}
+		}
+		os.Exit(1)
 	}
+}
 
-	var config Config
-	if err := json.Unmarshal(configData, &config); err != nil {
-		fmt.Printf("Error parsing config file: %v\n", err)
-		return
+func run(ctx context.Context, opts options) error {
+	if opts.verbose && opts.quiet {
+		return errors.New("-verbose and -quiet can't be used together")
+	}
+	logger := &merger.Logger{Level: merger.LogNormal, Out: os.Stderr}
+	if opts.verbose {
+		logger.Level = merger.LogVerbose
+	}
+	if opts.quiet {
+		logger.Level = merger.LogQuiet
 	}
 
-	// --- Handle Output Path ---
-	output := config.Dest.Output
-	if output == "" {
-		now := time.Now()
-		output = fmt.Sprintf("./dest/output_%02d_%02d_%d_%02d_%02d_%02d.mp4",
-			now.Day(), now.Month(), now.Year(),
-			now.Hour(), now.Minute(), now.Second())
+	// --- Load Config ---
+	configFile := opts.configFile
+	f, err := os.Open(configFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("config file not found: %s", configFile)
+		}
+		return fmt.Errorf("error reading config file '%s': %w", configFile, err)
+	}
+	config, err := merger.DecodeConfigFile(f, configFile)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("error parsing config file '%s': %w", configFile, err)
 	}
 
-	// --- Load Videos ---
-	videos := config.Source
-	if len(videos) == 0 {
-		videos, err = getVideoFiles("./source")
+	// --- Read File List ---
+	if opts.files != "" {
+		videos, err := readFileList(opts.files)
 		if err != nil {
-			fmt.Printf("Error reading source directory: %v\n", err)
-			return
+			return err
 		}
+		// The list is used verbatim: no scanning, sorting, reordering, or
+		// deduping. An explicit -reverse still flips it, below.
+		config.Source = merger.SourcePaths(videos)
+		config.Scan.SortBy = merger.SortByName
+		config.Scan.SortMode = merger.SortNone
+		config.Scan.Order = merger.OrderAsc
+		config.Reverse = false
+		config.DedupeConsecutive = false
 	}
-	sort.Strings(videos)
 
-	// --- Prepare Output Directory ---
-	if err := os.MkdirAll(config.Dest.IntermediateTextDir, 0755); err != nil {
-		fmt.Printf("Error creating intermediate text directory: %v\n", err)
-		return
+	// --- Filter By Date ---
+	if opts.since != "" {
+		config.Scan.Since = opts.since
 	}
-	defer os.RemoveAll(config.Dest.IntermediateTextDir)
-
-	// --- Load Font ---
-	face, err := gg.LoadFontFace(config.Font.Path, config.Font.Size)
-	if err != nil {
-		fmt.Printf("Error loading font from path '%s': %v\n", config.Font.Path, err)
-		return
+	if opts.until != "" {
+		config.Scan.Until = opts.until
 	}
 
-	textColor := hexToRGBA(config.Text.Color)
-	bgColor := hexToRGBA(config.Text.Background)
+	// --- Reverse Order ---
+	if opts.reverse {
+		config.Reverse = true
+	}
 
-	// --- Generate Transition Frames ---
-	for i, video := range videos {
-		if i == 0 {
-			continue
-		}
+	// --- Random Styles ---
+	if opts.seedSet {
+		config.Seed = opts.seed
+	}
 
-		text := fmt.Sprintf("Next: %s", filepath.Base(video))
-		numFrames := config.Frame.Rate * config.Text.Duration
+	// --- List Videos ---
+	if opts.list {
+		return listVideos(config)
+	}
 
-		for j := 0; j < numFrames; j++ {
-			framePath := fmt.Sprintf("%s/text_%d_frame_%05d.png", config.Dest.IntermediateTextDir, i, j)
-			dc := gg.NewContext(config.Frame.Width, config.Frame.Height)
-			dc.SetColor(bgColor)
-			dc.Clear()
-			dc.SetColor(textColor)
-			dc.SetFontFace(face)
-			dc.DrawStringAnchored(text, float64(config.Frame.Width)/2, float64(config.Frame.Height)/2, 0.5, 0.5)
-			if err := dc.SavePNG(framePath); err != nil {
-				fmt.Printf("Error saving frame: %v\n", err)
-				return
-			}
+	// --- Validate Config ---
+	if errs := merger.ValidateConfig(config); len(errs) > 0 {
+		var msg strings.Builder
+		fmt.Fprintf(&msg, "invalid config file '%s':", configFile)
+		for _, err := range errs {
+			fmt.Fprintf(&msg, "\n  - %v", err)
 		}
+		return &merger.Error{Kind: merger.ErrConfigInvalid, Err: errors.New(msg.String())}
 	}
 
-	// --- Create File List ---
-	tempFile, err := os.CreateTemp("", "filelist_*.txt")
-	if err != nil {
-		fmt.Printf("Error creating filelist: %v\n", err)
-		return
+	// The video itself goes to stdout, so nothing else can.
+	toStdout := config.Dest.Output == merger.StdoutOutput
+	if toStdout && (opts.json || opts.watch) {
+		return errors.New("-json and -watch can't be used with output to stdout")
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	// --- Create Transition Videos & Append to File List ---
-	for i, video := range videos {
-		if i > 0 {
-			textFramesPattern := fmt.Sprintf("%s/text_%d_frame_%%05d.png", config.Dest.IntermediateTextDir, i)
-			textVideo := fmt.Sprintf("text_transition_%d.mp4", i)
+	// --- Dry Run ---
+	if opts.dryRun {
+		return merger.DryRun(config, os.Stdout)
+	}
 
-			cmd := exec.Command("ffmpeg", "-y", "-framerate", fmt.Sprintf("%d", config.Frame.Rate),
-				"-i", textFramesPattern, "-c:v", "libx264", "-pix_fmt", "yuv420p", textVideo)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+	// --- Preview Cards ---
+	if opts.previewDir != "" {
+		paths, err := merger.PreviewCards(config, opts.previewDir)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		return nil
+	}
 
-			if err := cmd.Run(); err != nil {
-				fmt.Printf("Error creating text transition video: %v\n", err)
-				return
-			}
-			defer os.Remove(textVideo)
+	// --- Check Tools ---
+	if err := merger.CheckTools(config); err != nil {
+		return err
+	}
 
-			if _, err := tempFile.WriteString(fmt.Sprintf("file '%s'\n", textVideo)); err != nil {
-				fmt.Printf("Error writing to filelist: %v\n", err)
-				return
+	// --- Check Frame Counts ---
+	if opts.force {
+		config.Force = true
+	} else if !opts.watch {
+		if err := merger.CheckFrameCounts(config); err != nil {
+			if !errors.Is(err, merger.ErrTooManyFrames) {
+				return err
 			}
-		}
-		if _, err := tempFile.WriteString(fmt.Sprintf("file '%s'\n", video)); err != nil {
-			fmt.Printf("Error writing video to filelist: %v\n", err)
-			return
+			if !interactive() {
+				return fmt.Errorf("%w; run with -force to merge anyway", err)
+			}
+			if !confirm(err.Error() + "\nContinue anyway?") {
+				return &merger.Error{Kind: merger.ErrTooManyFrames, Err: errors.New("merge canceled")}
+			}
+			config.Force = true
 		}
 	}
 
-	if err := tempFile.Sync(); err != nil {
-		fmt.Printf("Error syncing filelist: %v\n", err)
-		return
+	// --- Metrics ---
+	flushMetrics := func() {}
+	if opts.metricsAddr != "" || opts.metricsFile != "" {
+		reg := prometheus.NewRegistry()
+		if config.Metrics, err = merger.NewMetrics(reg); err != nil {
+			return err
+		}
+		if opts.metricsAddr != "" {
+			stop, err := serveMetrics(opts.metricsAddr, reg)
+			if err != nil {
+				return err
+			}
+			defer stop()
+			logger.Infof("Serving metrics on http://%s/metrics", opts.metricsAddr)
+		}
+		if opts.metricsFile != "" {
+			flushMetrics = func() { writeMetrics(opts.metricsFile, reg) }
+		}
 	}
 
 	// --- Merge Videos ---
-	fmt.Println("Merging videos into:", output)
-	cmd := exec.Command("ffmpeg", "-y", "-f", "concat", "-safe", "0", "-i", tempFile.Name(), "-c", "copy", output)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	config.Logger = logger
+	config.KeepIntermediates = opts.keep
+	if opts.logFile != "" {
+		config.LogFile = opts.logFile
+	}
+	if opts.threads > 0 {
+		config.Threads = opts.threads
+	}
+	if opts.maxDuration > 0 {
+		config.MaxDuration = opts.maxDuration
+	}
+	if !opts.quiet {
+		config.Progress = renderProgress
+	}
+	if opts.watch {
+		err := merger.Watch(ctx, config, watchDebounce, func(result merger.Result, err error) {
+			flushMetrics()
+			stamp := time.Now().Format(time.DateTime)
+			if err != nil {
+				log.Printf("[%s] %v", stamp, err)
+				return
+			}
+			log.Printf("[%s] ✅ Videos merged successfully into %s", stamp, result.Output)
+		})
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Error merging videos: %v\n", err)
-		return
+	result, err := merger.MergeContext(ctx, config)
+	flushMetrics()
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("✅ Videos merged successfully into", output)
+	if opts.json {
+		printJSON(jsonSummary{
+			Output:         result.Output,
+			Poster:         result.Poster,
+			Clips:          result.Clips,
+			Transitions:    result.Transitions,
+			Frames:         result.Frames,
+			ElapsedSeconds: result.Elapsed.Seconds(),
+			OutputBytes:    result.OutputBytes,
+		})
+		return nil
+	}
+	if toStdout {
+		log.Print("✅ Videos merged successfully to stdout")
+		return nil
+	}
+	fmt.Println("✅ Videos merged successfully into", result.Output)
+	return nil
 }